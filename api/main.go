@@ -3,7 +3,10 @@ package main
 import (
 	"html/template"
 	"log"
+	"os"
 	"strings"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -20,73 +23,382 @@ func contains(substring, str string) bool {
 	return strings.Contains(str, substring)
 }
 
-func init() {
-	database.LoadEnvs()
-	database.InitializeDatabaseLayer()
+// localTime converts a time or time pointer to the business time zone
+func localTime(value interface{}) (time.Time, bool) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return t, false
+		}
+		t = *v
+	default:
+		return t, false
+	}
+	if t.IsZero() {
+		return t, false
+	}
+	return t.In(repositories.BusinessLocation), true
+}
+
+func formatTime(value interface{}) string {
+	if t, ok := localTime(value); ok {
+		return t.Format("3:04 PM")
+	}
+	return ""
+}
+
+func formatDate(value interface{}) string {
+	if t, ok := localTime(value); ok {
+		return t.Format("2006-01-02")
+	}
+	return ""
+}
+
+func formatDateTime(value interface{}) string {
+	if t, ok := localTime(value); ok {
+		return t.Format("2006-01-02 15:04")
+	}
+	return ""
+}
 
-	// Check if tables exist first
-	if !database.TablesExist() {
-		log.Println("Tables do not exist. Running migrations...")
-		if err := database.Migrate(); err != nil {
-			log.Fatalf("Failed to migrate database: %v", err)
+// newNotifier picks how customer messages are sent, NOTIFIER=gateway posts
+// them to an HTTP messaging gateway, anything else writes them to a file or the log
+func newNotifier() services.Notifier {
+	if os.Getenv("NOTIFIER") == "gateway" {
+		channel := os.Getenv("NOTIFY_CHANNEL")
+		if channel == "" {
+			channel = "whatsapp"
 		}
-		log.Println("Migrations completed successfully")
-	} else {
-		log.Println("Tables already exist. Skipping migrations")
+		return services.NewGatewayNotifier(os.Getenv("NOTIFY_GATEWAY_URL"), os.Getenv("NOTIFY_GATEWAY_TOKEN"), channel)
+	}
+	return services.NewFileNotifier(os.Getenv("NOTIFY_LOG_PATH"))
+}
+
+// newMailer picks how staff emails are sent, MAILER=smtp sends them through
+// an SMTP server, anything else drops them as files into MAIL_DROP_DIR
+func newMailer() services.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	if os.Getenv("MAILER") == "smtp" {
+		return services.NewSMTPMailer(os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	}
+	return services.NewFileMailer(os.Getenv("MAIL_DROP_DIR"), from)
+}
+
+func init() {
+	database.LoadEnvs()
+	if err := repositories.SetBusinessLocation(os.Getenv("TIMEZONE")); err != nil {
+		log.Fatalf("Invalid TIMEZONE: %v", err)
+	}
+	database.BusinessLocation = repositories.BusinessLocation
+	if err := services.SetTaxPercent(os.Getenv("TAX_PERCENT")); err != nil {
+		log.Fatalf("Invalid TAX_PERCENT: %v", err)
+	}
+	if name := os.Getenv("BUSINESS_NAME"); name != "" {
+		services.BusinessName = name
+	}
+	services.PublicURL = os.Getenv("PUBLIC_URL")
+	if err := services.SetSessionLifetimes(os.Getenv("ACCESS_TOKEN_TTL"), os.Getenv("REFRESH_TOKEN_TTL")); err != nil {
+		log.Fatalf("Invalid session lifetime: %v", err)
+	}
+	if err := services.SetTwoFactorRoles(os.Getenv("TWO_FACTOR_ROLES")); err != nil {
+		log.Fatalf("Invalid TWO_FACTOR_ROLES: %v", err)
+	}
+	// Browsers only send Secure cookies over HTTPS, COOKIE_SECURE=false allows plain HTTP during development
+	middleware.CookieSecure = os.Getenv("COOKIE_SECURE") != "false"
+	if err := database.InitializeDatabaseLayer(); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	db = database.GetDB()
 }
 
 func main() {
+	// "migrate" manages the schema by hand instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// Bring the schema up to date before serving
+	if err := database.MigrateUp(false, os.Stdout); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	// Create repository
 	vehicleRepo := repositories.NewVehicleRepository(db)
+	packageRepo := repositories.NewPackageRepository(db)
+	userRepo := repositories.NewUserRepository(db)
+	customerRepo := repositories.NewCustomerRepository(db)
+	registryRepo := repositories.NewRegisteredVehicleRepository(db)
+	invoiceRepo := repositories.NewInvoiceRepository(db)
+	eventRepo := repositories.NewVehicleEventRepository(db)
+	bayRepo := repositories.NewBayRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+	webhookRepo := repositories.NewWebhookRepository(db)
+	sessionRepo := repositories.NewSessionRepository(db)
+	loginAttemptRepo := repositories.NewLoginAttemptRepository(db)
+	inviteRepo := repositories.NewInviteRepository(db)
+	passwordResetRepo := repositories.NewPasswordResetRepository(db)
+	recoveryCodeRepo := repositories.NewRecoveryCodeRepository(db)
 
 	// Create service
-	vehicleService := services.NewVehicleService(vehicleRepo)
+	broker := services.NewBroker()
+	estimator := services.NewEstimator(packageRepo, eventRepo, bayRepo)
+	invoiceService := services.NewInvoiceService(invoiceRepo, vehicleRepo, packageRepo)
+	notificationService := services.NewNotificationService(notificationRepo, newNotifier())
+	webhookService := services.NewWebhookService(webhookRepo)
+	vehicleService := services.NewVehicleService(vehicleRepo, eventRepo, bayRepo, packageRepo, customerRepo, registryRepo, invoiceService, notificationService, webhookService, estimator, broker)
+	packageService := services.NewPackageService(packageRepo)
+	bayService := services.NewBayService(bayRepo)
+	userService := services.NewUserService(userRepo)
+	customerService := services.NewCustomerService(customerRepo, vehicleRepo)
+	registryService := services.NewRegistryService(registryRepo, vehicleRepo)
+	trackingService := services.NewTrackingService(vehicleRepo)
+	sessionService := services.NewSessionService(sessionRepo)
+	twoFactorService := services.NewTwoFactorService(userRepo, recoveryCodeRepo)
+	loginService := services.NewLoginService(userRepo, loginAttemptRepo, twoFactorService)
+	accountService := services.NewAccountService(userRepo, inviteRepo, passwordResetRepo, sessionService, newMailer())
 
 	// Create handler
-	vehicleHandler := handlers.NewVehicleHandler(vehicleService)
+	vehicleHandler := handlers.NewVehicleHandler(vehicleService, packageService, bayService, invoiceService, notificationService)
+	packageHandler := handlers.NewPackageHandler(packageService)
+	bayHandler := handlers.NewBayHandler(bayService)
+	userHandler := handlers.NewUserHandler(userService, loginService, accountService, twoFactorService)
+	customerHandler := handlers.NewCustomerHandler(customerService)
+	registryHandler := handlers.NewRegistryHandler(registryService)
+	invoiceHandler := handlers.NewInvoiceHandler(invoiceService, vehicleService)
+	printHandler := handlers.NewPrintHandler(vehicleService, invoiceService)
+	trackHandler := handlers.NewTrackHandler(trackingService)
+	boardHandler := handlers.NewBoardHandler(trackingService, broker)
+	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	vehicleAPIHandler := handlers.NewVehicleAPIHandler(vehicleService, invoiceService)
+	authHandler := handlers.NewAuthHandler(loginService, sessionService, twoFactorService)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	accountHandler := handlers.NewAccountHandler(accountService, userService, twoFactorService)
+
+	// Only accept tokens of sessions that have not been signed out
+	middleware.UseSessions(sessionService)
+
+	// Retry messages the gateway did not take and failed webhook deliveries in the background
+	go notificationService.RetryFailed(time.Minute)
+	go webhookService.RetryDue(15 * time.Second)
 
 	// setup gin router
 	router := gin.Default()
+	// Client addresses drive the login rate limits, so X-Forwarded-For is only
+	// believed when it comes from one of the proxies in TRUSTED_PROXIES
+	var proxies []string
+	if trusted := os.Getenv("TRUSTED_PROXIES"); trusted != "" {
+		proxies = strings.Split(trusted, ",")
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(gin.Logger())
 
 	// Load HTML templates
 	router.SetFuncMap(template.FuncMap{
-		"contains": contains, // Now you can use {{contains}} in templates
+		"contains":          contains, // Now you can use {{contains}} in templates
+		"formatTime":        formatTime,
+		"formatDate":        formatDate,
+		"formatDateTime":    formatDateTime,
+		"formatRupiah":      services.FormatRupiah,
+		"requiresTwoFactor": services.RequiresTwoFactor, // Whether a role has to use two-factor authentication
+		"csrfField":         middleware.CSRFField,       // Hidden input with the request's CSRF token, for every POST form
 	})
 	router.LoadHTMLGlob("templates/*")
+	router.HTMLRender = middleware.NewCSRFRender(router.HTMLRender)
+	// Every form post has to carry the browser's CSRF token
+	router.Use(middleware.CSRF)
+	// Role checks shared by the HTML and JSON routes
+	manage := middleware.RequireRole(repositories.RoleOwner, repositories.RoleAdmin)
+	checkIn := middleware.RequireRole(repositories.RoleOwner, repositories.RoleAdmin, repositories.RoleCashier)
+	wash := middleware.RequireRole(repositories.RoleOwner, repositories.RoleAdmin, repositories.RoleWasher)
+
 	// Auth routes
 	auth := router.Group("/")
 	{
 		auth.GET("", handlers.Home)
-		auth.GET("/login", handlers.Login)
-		auth.GET("/logout", handlers.Logout)
-		auth.GET("/register", handlers.CreateUser)
-		auth.POST("/login", handlers.Login)
-		auth.POST("/register", handlers.CreateUser)
+		auth.GET("/login", authHandler.Login)
+		auth.GET("/register", accountHandler.Register)
+		auth.GET("/reset/:token", accountHandler.ResetPassword)
+		auth.POST("/login", authHandler.Login)
+		auth.GET("/login/2fa", authHandler.LoginCode)
+		auth.POST("/login/2fa", authHandler.LoginCode)
+		auth.POST("/logout", middleware.CheckAuth, authHandler.Logout)
+		auth.POST("/register", accountHandler.Register)
+		auth.POST("/reset/:token", accountHandler.ResetPassword)
 	}
 
 	// Vehicle routes
 	snip := router.Group("/vehicles")
 	{
-		// Guest routes
-		snip.GET("", vehicleHandler.GetVehiclesByProcess)
-		snip.GET("/:id", vehicleHandler.GetVehicleByID)
-
-		// Authenticated routes
-		snip.GET("/new", middleware.CheckAuth, vehicleHandler.CreateVehicle)
-		snip.POST("/new", middleware.CheckAuth, vehicleHandler.CreateVehicle)
-		snip.GET("/:id/edit", middleware.CheckAuth, vehicleHandler.UpdateVehicle)
-		snip.POST("/:id/edit", middleware.CheckAuth, vehicleHandler.UpdateVehicle)
-		snip.POST("/:id/delete", middleware.CheckAuth, vehicleHandler.DeleteVehicle)
-		snip.GET("/:id/delete", middleware.CheckAuth, vehicleHandler.DeleteVehicle)
-		snip.POST("/:id/selesai", middleware.CheckAuth, vehicleHandler.ChangeVehicleProcessToFinish)
-		snip.GET("/:id/selesai", middleware.CheckAuth, vehicleHandler.ChangeVehicleProcessToFinish)
-		snip.POST("/:id/proses", middleware.CheckAuth, vehicleHandler.ChangeVehicleProcessToWashing)
-		snip.GET("/:id/proses", middleware.CheckAuth, vehicleHandler.ChangeVehicleProcessToWashing)
+		// Authenticated routes, customers follow their own visit on /track
+		snip.GET("", middleware.CheckAuth, vehicleHandler.GetVehiclesByProcess)
+		snip.GET("/stream", middleware.CheckAuth, boardHandler.VehicleStream)
+		snip.GET("/:id", middleware.CheckAuth, vehicleHandler.GetVehicleByID)
+		snip.GET("/:id/events", middleware.CheckAuth, vehicleHandler.GetVehicleEvents)
+		snip.GET("/close", middleware.CheckAuth, manage, vehicleHandler.CloseDay)
+		snip.POST("/close", middleware.CheckAuth, manage, vehicleHandler.CloseDay)
+		snip.GET("/new", middleware.CheckAuth, checkIn, vehicleHandler.CreateVehicle)
+		snip.POST("/new", middleware.CheckAuth, checkIn, vehicleHandler.CreateVehicle)
+		snip.GET("/:id/edit", middleware.CheckAuth, manage, vehicleHandler.UpdateVehicle)
+		snip.POST("/:id/edit", middleware.CheckAuth, manage, vehicleHandler.UpdateVehicle)
+		snip.POST("/:id/delete", middleware.CheckAuth, checkIn, vehicleHandler.DeleteVehicle)
+		snip.POST("/:id/transition", middleware.CheckAuth, wash, vehicleHandler.TransitionVehicle)
+		snip.POST("/:id/pickup", middleware.CheckAuth, checkIn, vehicleHandler.PickupVehicle)
+		snip.POST("/:id/notifications/:notification/retry", middleware.CheckAuth, checkIn, vehicleHandler.RetryNotification)
+
+		// Invoice routes (front desk, discounts need a manager)
+		snip.GET("/:id/invoice", middleware.CheckAuth, checkIn, invoiceHandler.GetInvoice)
+		snip.POST("/:id/invoice", middleware.CheckAuth, checkIn, invoiceHandler.OpenInvoice)
+		snip.POST("/:id/invoice/items", middleware.CheckAuth, checkIn, invoiceHandler.AddItem)
+		snip.POST("/:id/invoice/items/:item/delete", middleware.CheckAuth, checkIn, invoiceHandler.RemoveItem)
+		snip.POST("/:id/invoice/discount", middleware.CheckAuth, manage, invoiceHandler.SetDiscount)
+		snip.POST("/:id/invoice/pay", middleware.CheckAuth, checkIn, invoiceHandler.Pay)
+
+		// Print routes (front desk)
+		snip.GET("/:id/ticket", middleware.CheckAuth, checkIn, printHandler.Ticket)
+		snip.GET("/:id/receipt", middleware.CheckAuth, checkIn, printHandler.Receipt)
+
+	}
+
+	// Public status page of a single visit, reached from the ticket's QR code
+	track := router.Group("/track")
+	{
+		track.GET("/:token", trackHandler.Track)
+		track.GET("/:token/status", trackHandler.Status)
+	}
+
+	// Waiting room TV board, public as it only shows queue numbers
+	board := router.Group("/board")
+	{
+		board.GET("", boardHandler.Board)
+		board.GET("/data", boardHandler.BoardData)
+		board.GET("/stream", boardHandler.BoardStream)
+	}
+
+	// Package catalog routes (managers only)
+	pkg := router.Group("/packages", middleware.CheckAuth, manage)
+	{
+		pkg.GET("", packageHandler.GetPackages)
+		pkg.GET("/new", packageHandler.CreatePackage)
+		pkg.POST("/new", packageHandler.CreatePackage)
+		pkg.GET("/:id/edit", packageHandler.UpdatePackage)
+		pkg.POST("/:id/edit", packageHandler.UpdatePackage)
+		pkg.POST("/:id/delete", packageHandler.DeletePackage)
+	}
+
+	// Wash bay routes (managers only)
+	bay := router.Group("/bays", middleware.CheckAuth, manage)
+	{
+		bay.GET("", bayHandler.GetBays)
+		bay.GET("/new", bayHandler.CreateBay)
+		bay.POST("/new", bayHandler.CreateBay)
+		bay.GET("/:id/edit", bayHandler.UpdateBay)
+		bay.POST("/:id/edit", bayHandler.UpdateBay)
+		bay.POST("/:id/delete", bayHandler.DeleteBay)
+	}
+
+	// Customer routes (front desk)
+	customer := router.Group("/customers", middleware.CheckAuth, checkIn)
+	{
+		customer.GET("", customerHandler.GetCustomers)
+		customer.GET("/search", customerHandler.SearchCustomers)
+		customer.GET("/:id", customerHandler.GetCustomerByID)
+		customer.GET("/:id/edit", customerHandler.UpdateCustomer)
+		customer.POST("/:id/edit", customerHandler.UpdateCustomer)
+	}
+
+	// Vehicle registry routes (front desk)
+	registry := router.Group("/registry", middleware.CheckAuth, checkIn)
+	{
+		registry.GET("", registryHandler.GetRegisteredVehicles)
+		registry.GET("/lookup", registryHandler.LookupPlate)
+		registry.GET("/:id", registryHandler.GetRegisteredVehicleByID)
+		registry.GET("/:id/edit", registryHandler.UpdateRegisteredVehicle)
+		registry.POST("/:id/edit", registryHandler.UpdateRegisteredVehicle)
+	}
+
+	// Customer message templates (managers only)
+	notification := router.Group("/notifications", middleware.CheckAuth, manage)
+	{
+		notification.GET("", notificationHandler.GetTemplates)
+		notification.POST("/:id", notificationHandler.UpdateTemplate)
+	}
+
+	// Webhook routes (managers only)
+	webhook := router.Group("/webhooks", middleware.CheckAuth, manage)
+	{
+		webhook.GET("", webhookHandler.GetWebhooks)
+		webhook.GET("/new", webhookHandler.CreateWebhook)
+		webhook.POST("/new", webhookHandler.CreateWebhook)
+		webhook.GET("/:id", webhookHandler.GetWebhook)
+		webhook.GET("/:id/edit", webhookHandler.UpdateWebhook)
+		webhook.POST("/:id/edit", webhookHandler.UpdateWebhook)
+		webhook.POST("/:id/delete", webhookHandler.DeleteWebhook)
+		webhook.POST("/:id/deliveries/:delivery/redeliver", webhookHandler.Redeliver)
+	}
+
+	// The signed in user's own account
+	account := router.Group("/account", middleware.CheckAuth)
+	{
+		account.GET("", accountHandler.GetAccount)
+		account.POST("/email", accountHandler.UpdateEmail)
+		account.POST("/password", accountHandler.ChangePassword)
+		account.GET("/2fa", accountHandler.SetupTwoFactor)
+		account.POST("/2fa", accountHandler.SetupTwoFactor)
+		account.POST("/2fa/recovery-codes", accountHandler.RegenerateRecoveryCodes)
+		account.POST("/2fa/disable", accountHandler.DisableTwoFactor)
+	}
+
+	// Devices the user is signed in on
+	session := router.Group("/sessions", middleware.CheckAuth)
+	{
+		session.GET("", sessionHandler.GetSessions)
+		session.POST("/revoke-all", sessionHandler.RevokeAll)
+		session.POST("/:id/revoke", sessionHandler.Revoke)
+	}
+
+	// User role routes (managers only)
+	user := router.Group("/users", middleware.CheckAuth, manage)
+	{
+		user.GET("", userHandler.GetUsers)
+		user.GET("/logins", userHandler.GetLoginAttempts)
+		user.POST("/:id/role", userHandler.AssignRole)
+		user.POST("/:id/unlock", userHandler.Unlock)
+		user.POST("/:id/reset", userHandler.ResetPassword)
+		user.POST("/:id/2fa/reset", userHandler.ResetTwoFactor)
+		user.POST("/invites", userHandler.CreateInvite)
+		user.POST("/invites/:id/delete", userHandler.RevokeInvite)
+	}
+
+	// JSON API
+	v1 := router.Group("/api/v1")
+	{
+		v1.POST("/login", authHandler.APILogin)
+		v1.POST("/refresh", authHandler.APIRefresh)
+		v1.POST("/logout", middleware.CheckAuth, authHandler.APILogout)
 
+		vehicles := v1.Group("/vehicles", middleware.CheckAuth)
+		vehicles.GET("", vehicleAPIHandler.ListVehicles)
+		vehicles.POST("", checkIn, vehicleAPIHandler.CreateVehicle)
+		vehicles.GET("/:id", vehicleAPIHandler.GetVehicle)
+		vehicles.PUT("/:id", manage, vehicleAPIHandler.UpdateVehicle)
+		vehicles.DELETE("/:id", checkIn, vehicleAPIHandler.DeleteVehicle)
+		vehicles.GET("/:id/events", vehicleAPIHandler.GetVehicleEvents)
+		vehicles.GET("/:id/invoice", checkIn, vehicleAPIHandler.GetInvoice)
+		vehicles.POST("/:id/transition", wash, vehicleAPIHandler.TransitionVehicle)
 	}
+	router.NoRoute(handlers.NotFound)
 
 	// start server
 	log.Println("starting server on :8080")
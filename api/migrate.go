@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"nevacarwash.com/main/database"
+)
+
+// runMigrateCommand handles "migrate [-dry-run] [up | down [-steps n] | status]"
+func runMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print the SQL of pending migrations without applying it")
+	steps := flags.Int("steps", 1, "number of migrations to revert with down")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: migrate [-dry-run] [up | down [-steps n] | status]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// Flags may also follow the command, e.g. "migrate down -steps 2"
+	command := "up"
+	if flags.NArg() > 0 {
+		command = flags.Arg(0)
+		flags.Parse(flags.Args()[1:])
+	}
+
+	switch command {
+	case "up":
+		return database.MigrateUp(*dryRun, os.Stdout)
+	case "down":
+		if *steps < 1 {
+			return fmt.Errorf("steps must be at least 1")
+		}
+		return database.MigrateDown(*steps, *dryRun, os.Stdout)
+	case "status":
+		return database.MigrationStatus(os.Stdout)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+}
@@ -2,8 +2,13 @@ package database
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"time"
 
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -15,6 +20,29 @@ func GetDB() *gorm.DB {
 	return dbInstance
 }
 
+func gormConfig() *gorm.Config {
+	return &gorm.Config{
+		// Log statements without their values, they carry password hashes,
+		// reset tokens and authenticator secrets
+		Logger: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold:        200 * time.Millisecond,
+			LogLevel:             logger.Info,
+			Colorful:             true,
+			ParameterizedQueries: true,
+		}),
+		// Store every timestamp in UTC, the business time zone is only applied when displaying
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// envOr returns the environment variable, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func setupSQLite() (*gorm.DB, error) {
 	dbLocation := os.Getenv("DATABASE_PATH")
 	if dbLocation == "" {
@@ -28,12 +56,84 @@ func setupSQLite() (*gorm.DB, error) {
 		}
 	}
 
-	db, err := gorm.Open(sqlite.Open(dbLocation), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
+	// Wait on locks instead of failing, and take the write lock when a
+	// transaction starts so concurrent check-ins queue up instead of deadlocking
+	dsn := dbLocation + "?_busy_timeout=5000&_txlock=immediate"
+
+	db, err := gorm.Open(sqlite.Open(dsn), gormConfig())
+	return db, err
+}
+
+// setupPostgres connects with DB_DSN, or builds the DSN from the DB_HOST,
+// DB_PORT, DB_USER, DB_PASSWORD, DB_NAME and DB_SSLMODE variables
+func setupPostgres() (*gorm.DB, error) {
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		dsn = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC",
+			envOr("DB_HOST", "localhost"),
+			envOr("DB_PORT", "5432"),
+			os.Getenv("DB_USER"),
+			os.Getenv("DB_PASSWORD"),
+			envOr("DB_NAME", "nevacarwash"),
+			envOr("DB_SSLMODE", "disable"),
+		)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), gormConfig())
 	return db, err
 }
 
+// setupMySQL connects with DB_DSN, or builds the DSN from the DB_HOST,
+// DB_PORT, DB_USER, DB_PASSWORD and DB_NAME variables. A custom DSN must keep
+// parseTime=true and loc=UTC so timestamps round trip unchanged.
+func setupMySQL() (*gorm.DB, error) {
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&loc=UTC",
+			os.Getenv("DB_USER"),
+			os.Getenv("DB_PASSWORD"),
+			envOr("DB_HOST", "localhost"),
+			envOr("DB_PORT", "3306"),
+			envOr("DB_NAME", "nevacarwash"),
+		)
+	}
+
+	db, err := gorm.Open(mysql.Open(dsn), gormConfig())
+	return db, err
+}
+
+// configurePool applies DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME (e.g. "30m") when they are set
+func configurePool(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	if value := os.Getenv("DB_MAX_OPEN_CONNS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid DB_MAX_OPEN_CONNS: %s", value)
+		}
+		sqlDB.SetMaxOpenConns(n)
+	}
+	if value := os.Getenv("DB_MAX_IDLE_CONNS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid DB_MAX_IDLE_CONNS: %s", value)
+		}
+		sqlDB.SetMaxIdleConns(n)
+	}
+	if value := os.Getenv("DB_CONN_MAX_LIFETIME"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid DB_CONN_MAX_LIFETIME: %s", value)
+		}
+		sqlDB.SetConnMaxLifetime(d)
+	}
+	return nil
+}
+
 func InitializeDatabaseLayer() error {
 	dbs := os.Getenv("DB")
 	var db *gorm.DB
@@ -42,14 +142,22 @@ func InitializeDatabaseLayer() error {
 	switch dbs {
 	case "sqlite":
 		db, err = setupSQLite()
-		break
-	default:
+	case "postgres":
+		db, err = setupPostgres()
+	case "mysql":
+		db, err = setupMySQL()
+	case "":
 		return fmt.Errorf("No database found, set the DB env")
+	default:
+		return fmt.Errorf("Unsupported database %q, use sqlite, postgres or mysql", dbs)
 	}
 	if err != nil {
 		return err
 	}
+	if err := configurePool(db); err != nil {
+		return err
+	}
 
 	dbInstance = db
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BusinessLocation is the time zone legacy vehicle data is read in, and the
+// zone queue sequences are named by. Set it before migrating.
+var BusinessLocation = time.Local
+
+// The helpers below are frozen copies of the repositories code the
+// migrations ran with when they shipped. The live code may change, these
+// must keep backfilling exactly what they always did.
+
+// Roles as migration 4 assigns them
+const (
+	roleOwner0004   = "owner"
+	roleAdmin0004   = "admin"
+	roleCashier0004 = "cashier"
+)
+
+// vehicleClasses0005 are the classes migration 5 gives the seeded bay
+var vehicleClasses0005 = []string{"Motor", "Motor Besar", "Mobil", "Mobil Besar"}
+
+// queueSequence0005 names the counter behind the queue numbers of a business day
+func queueSequence0005(date time.Time) string {
+	return "queue:" + date.In(BusinessLocation).Format("2006-01-02")
+}
+
+// vehicleSequence0005 names the counter behind the username-N vehicle IDs of a user
+func vehicleSequence0005(userID uint) string {
+	return fmt.Sprintf("vehicle:%d", userID)
+}
+
+// normalizePhone0006 writes Indonesian numbers in their local 08... form, or
+// returns "" when there are too few digits to be a phone number
+func normalizePhone0006(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	normalized := digits.String()
+	if strings.HasPrefix(normalized, "62") {
+		normalized = "0" + strings.TrimPrefix(normalized, "62")
+	}
+	if len(normalized) < 6 {
+		return ""
+	}
+	return normalized
+}
+
+var platePattern0007 = regexp.MustCompile(`^([A-Z]{1,2})\s*([0-9]{1,4})\s*([A-Z]{0,3})$`)
+
+// normalizePlate0007 writes a plate the way it is printed, e.g. "B 1234 XYZ"
+func normalizePlate0007(plate string) string {
+	plate = strings.Join(strings.Fields(strings.ToUpper(plate)), " ")
+	compact := strings.ReplaceAll(plate, " ", "")
+	if match := platePattern0007.FindStringSubmatch(compact); match != nil {
+		return strings.TrimSpace(match[1] + " " + match[2] + " " + match[3])
+	}
+	return plate
+}
+
+// trackingToken0009 returns a random token for the public status page of a visit
+func trackingToken0009() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,35 +1,237 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"strings"
+	"time"
 
-	"nevacarwash.com/main/repositories"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
-func TablesExist() bool {
-	db := GetDB()
+// Migration is one versioned step of the schema. Up and Down receive the
+// transaction the step runs in; Down is nil when the step cannot be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
 
-	// Check if tables exist
-	hasUser := db.Migrator().HasTable(&repositories.User{})
-	hasVehicle := db.Migrator().HasTable(&repositories.Vehicle{})
+// SchemaMigration records a migration that has been applied
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
 
-	return hasUser && hasVehicle
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
 }
 
-func Migrate() error {
+// errDryRun rolls back migrations that were only run to print their SQL
+var errDryRun = errors.New("dry run")
+
+// appliedMigrations returns the applied migrations by version
+func appliedMigrations() (map[int]SchemaMigration, error) {
 	db := GetDB()
 
-	// Run migrations
-	err := db.AutoMigrate(
-		&repositories.User{},
-		&repositories.Vehicle{}, // Note: Changed from Vehicles to Vehicle to match model name
-	)
+	applied := map[int]SchemaMigration{}
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// PendingMigrations lists the migrations that have not been applied yet, oldest first
+func PendingMigrations() ([]Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies every pending migration in order. With dryRun set the
+// SQL each migration would run is written to out and nothing is changed.
+func MigrateUp(dryRun bool, out io.Writer) error {
+	pending, err := PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(out, "Schema is up to date")
+		return nil
+	}
+
+	if dryRun {
+		return previewMigrations(pending, true, out)
+	}
+	for _, migration := range pending {
+		if err := runMigration(migration, true); err != nil {
+			return fmt.Errorf("migration %04d %s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %04d %s", migration.Version, migration.Name)
+	}
+	return nil
+}
 
+// MigrateDown reverts the given number of applied migrations, newest first
+func MigrateDown(steps int, dryRun bool, out io.Writer) error {
+	applied, err := appliedMigrations()
 	if err != nil {
-		log.Printf("Failed to migrate database: %v", err)
 		return err
 	}
 
-	log.Println("Database migration completed successfully")
+	var reverting []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverting) < steps; i-- {
+		if _, ok := applied[migrations[i].Version]; ok {
+			reverting = append(reverting, migrations[i])
+		}
+	}
+	if len(reverting) == 0 {
+		fmt.Fprintln(out, "No migrations to revert")
+		return nil
+	}
+
+	for _, migration := range reverting {
+		if migration.Down == nil {
+			return fmt.Errorf("migration %04d %s cannot be reverted", migration.Version, migration.Name)
+		}
+	}
+
+	if dryRun {
+		return previewMigrations(reverting, false, out)
+	}
+	for _, migration := range reverting {
+		if err := runMigration(migration, false); err != nil {
+			return fmt.Errorf("reverting %04d %s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Reverted migration %04d %s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// MigrationStatus writes every known migration with the time it was applied
+func MigrationStatus(out io.Writer) error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		status := "pending"
+		if row, ok := applied[migration.Version]; ok {
+			status = "applied " + row.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%04d  %-36s %s\n", migration.Version, migration.Name, status)
+	}
 	return nil
 }
+
+// runMigration runs one direction of a migration and updates schema_migrations in the same transaction
+func runMigration(migration Migration, up bool) error {
+	return GetDB().Transaction(func(tx *gorm.DB) error {
+		return applyMigration(tx, migration, up)
+	})
+}
+
+// previewMigrations runs the migrations in one transaction that is rolled
+// back, writing the SQL each of them would execute to out
+func previewMigrations(list []Migration, up bool, out io.Writer) error {
+	db := GetDB()
+
+	// MySQL commits DDL implicitly, so the rollback would not undo anything
+	if db.Dialector.Name() == "mysql" {
+		fmt.Fprintln(out, "-- SQL preview is not available on mysql, pending migrations:")
+		for _, migration := range list {
+			fmt.Fprintf(out, "-- %04d %s\n", migration.Version, migration.Name)
+		}
+		return nil
+	}
+
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	capture := &sqlCapture{}
+	err := db.Session(&gorm.Session{Logger: capture}).Transaction(func(tx *gorm.DB) error {
+		for _, migration := range list {
+			fmt.Fprintf(out, "-- %04d %s (%s)\n", migration.Version, migration.Name, direction)
+			capture.statements = nil
+			if err := applyMigration(tx, migration, up); err != nil {
+				return fmt.Errorf("migration %04d %s: %w", migration.Version, migration.Name, err)
+			}
+			for _, statement := range capture.statements {
+				fmt.Fprintf(out, "%s;\n", statement)
+			}
+			fmt.Fprintln(out)
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+// applyMigration runs one direction of a migration inside tx and records it
+func applyMigration(tx *gorm.DB, migration Migration, up bool) error {
+	if err := tx.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	if !up {
+		if err := migration.Down(tx); err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaMigration{}, migration.Version).Error
+	}
+
+	if err := migration.Up(tx); err != nil {
+		return err
+	}
+	return tx.Create(&SchemaMigration{
+		Version:   migration.Version,
+		Name:      migration.Name,
+		AppliedAt: time.Now().UTC(),
+	}).Error
+}
+
+// sqlCapture is a gorm logger that keeps the statements changing the database
+type sqlCapture struct {
+	statements []string
+}
+
+func (c *sqlCapture) LogMode(logger.LogLevel) logger.Interface { return c }
+
+func (c *sqlCapture) Info(context.Context, string, ...interface{}) {}
+
+func (c *sqlCapture) Warn(context.Context, string, ...interface{}) {}
+
+func (c *sqlCapture) Error(context.Context, string, ...interface{}) {}
+
+func (c *sqlCapture) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	keyword := strings.ToUpper(strings.SplitN(strings.TrimSpace(sql), " ", 2)[0])
+	switch keyword {
+	case "", "SELECT", "PRAGMA", "SHOW", "SAVEPOINT", "RELEASE", "ROLLBACK":
+		return
+	}
+	c.statements = append(c.statements, sql)
+}
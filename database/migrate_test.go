@@ -0,0 +1,123 @@
+package database
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// openTestDB opens an empty SQLite database the way the server does
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("DB", "sqlite")
+	t.Setenv("DATABASE_PATH", filepath.Join(t.TempDir(), "test.db"))
+	if err := InitializeDatabaseLayer(); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	db := GetDB()
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// queuedVehicle is a vehicle row from before queue numbers were unique
+type queuedVehicle struct {
+	ID        string `gorm:"primaryKey"`
+	UserID    uint
+	Queue     int
+	Date      time.Time
+	EnterTime time.Time
+}
+
+func (queuedVehicle) TableName() string { return "vehicles" }
+
+func TestMigrateRenumbersDuplicateQueues(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&queuedVehicle{}); err != nil {
+		t.Fatalf("create old vehicles table: %v", err)
+	}
+	day := time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time { return day.Add(8*time.Hour + time.Duration(minute)*time.Minute) }
+	old := []queuedVehicle{
+		{ID: "ana-1", Queue: 1, Date: day, EnterTime: at(0)},
+		{ID: "budi-1", Queue: 1, Date: day, EnterTime: at(1)},
+		{ID: "ana-2", Queue: 2, Date: day, EnterTime: at(2)},
+		{ID: "budi-2", Queue: 2, Date: day, EnterTime: at(3)},
+		{ID: "ana-3", Queue: 2, Date: day, EnterTime: at(4)},
+		{ID: "ana-4", Queue: 1, Date: day.AddDate(0, 0, 1), EnterTime: at(24 * 60)},
+	}
+	if err := db.Create(&old).Error; err != nil {
+		t.Fatalf("insert old vehicles: %v", err)
+	}
+
+	if err := MigrateUp(false, io.Discard); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var rows []queuedVehicle
+	if err := db.Order("id").Find(&rows).Error; err != nil {
+		t.Fatalf("load vehicles: %v", err)
+	}
+	got := make(map[string]int)
+	for _, row := range rows {
+		got[row.ID] = row.Queue
+	}
+	// The first vehicle keeps its number, the ones clashing with it move to the end of their day
+	want := map[string]int{"ana-1": 1, "budi-1": 3, "ana-2": 2, "budi-2": 4, "ana-3": 5, "ana-4": 1}
+	for id, queue := range want {
+		if got[id] != queue {
+			t.Errorf("%s has queue %d, want %d", id, got[id], queue)
+		}
+	}
+}
+
+// The schema every database had before versioned migrations, times stored as text
+const legacySchema = "CREATE TABLE `users` (`id` integer PRIMARY KEY AUTOINCREMENT,`username` text,`password` text,`admin` numeric,`created_at` datetime,`updated_at` datetime,CONSTRAINT `uni_users_username` UNIQUE (`username`));" +
+	"CREATE TABLE `vehicles` (`id` text,`user_id` integer,`queue` integer,`name` text,`package` text,`plate` text,`process` text,`contact` text,`date` text,`enter_time` text,`estimated_time` text,`finish_time` text,PRIMARY KEY (`id`),CONSTRAINT `fk_users_vehicles` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`));"
+
+func TestMigrateUpRepairsLegacyDatabaseWithDuplicateQueues(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(legacySchema).Error; err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+	err := db.Exec("INSERT INTO users (id, username, password, admin) VALUES (1, '@admin', '', 1), (2, 'ana', '', 0)").Error
+	if err != nil {
+		t.Fatalf("insert legacy users: %v", err)
+	}
+	err = db.Exec(`INSERT INTO vehicles (id, user_id, queue, name, package, plate, process, contact, date, enter_time, estimated_time, finish_time) VALUES
+		('@admin-1', 1, 1, 'Budi', 'Mobil', 'B 1234 XYZ', 'Finish', '', '2025-03-14', '2:28 AM', '3:08 AM', '3:41 AM'),
+		('ana-1', 2, 1, 'Citra', 'Motor', 'B 2 AB', 'Waiting', '', '2025-03-14', '2:30 AM', '2:55 AM', ''),
+		('ana-2', 2, 2, 'Dewi', 'Motor', 'B 3 AB', 'Waiting', '', '2025-03-14', '2:31 AM', '2:56 AM', ''),
+		('ana-3', 2, 1, 'Eka', 'Mobil', 'B 4 AB', 'Waiting', '', '2025-03-15', '9:00 AM', '9:40 AM', '')`).Error
+	if err != nil {
+		t.Fatalf("insert legacy vehicles: %v", err)
+	}
+
+	if err := MigrateUp(false, io.Discard); err != nil {
+		t.Fatalf("migrate legacy database: %v", err)
+	}
+
+	var rows []struct {
+		ID    string
+		Queue int
+	}
+	if err := db.Table("vehicles").Select("id, queue").Scan(&rows).Error; err != nil {
+		t.Fatalf("load vehicles: %v", err)
+	}
+	got := make(map[string]int)
+	for _, row := range rows {
+		got[row.ID] = row.Queue
+	}
+	want := map[string]int{"@admin-1": 1, "ana-1": 3, "ana-2": 2, "ana-3": 1}
+	for id, queue := range want {
+		if got[id] != queue {
+			t.Errorf("%s has queue %d, want %d", id, got[id], queue)
+		}
+	}
+}
@@ -0,0 +1,909 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations lists every schema change in the order it is applied. Append new
+// steps with the next version, never edit or reorder one that has shipped.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "convert_legacy_vehicle_times",
+		Up: func(tx *gorm.DB) error {
+			// Convert "2006-01-02" / "3:04 PM" strings before the columns change type
+			if !hasLegacyVehicleTimes(tx) {
+				return nil
+			}
+			return convertLegacyVehicleTimes(tx)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "renumber_duplicate_queues",
+		Up: func(tx *gorm.DB) error {
+			// Queue numbers used to be counted outside a transaction and may clash
+			if !tx.Migrator().HasTable("vehicles") {
+				return nil
+			}
+			return renumberDuplicateQueues(tx)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "baseline_schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(
+				&baselineUser{},
+				&baselineBay{},
+				&baselineVehicle{},
+				&baselinePackage{},
+				&baselineVehicleEvent{},
+				&baselineSequence{},
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("vehicle_events", "vehicles", "sequences", "bays", "packages", "users")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "convert_user_admin_to_roles",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn("users", "admin") {
+				return nil
+			}
+			return convertLegacyUserAdmin(tx)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "seed_catalog",
+		Up: func(tx *gorm.DB) error {
+			if err := seedPackages(tx); err != nil {
+				return err
+			}
+			if err := seedBays(tx); err != nil {
+				return err
+			}
+			return seedSequences(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			// Seeded rows are ordinary data by now, leave them in place
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_customers",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&customer0006{}, &vehicle0006{}); err != nil {
+				return err
+			}
+			return linkVehicleCustomers(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&vehicle0006{}, "customer_id"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("customers")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_vehicle_registry",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&registeredVehicle0007{}, &vehicle0007{}); err != nil {
+				return err
+			}
+			return linkRegisteredVehicles(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&vehicle0007{}, "registered_vehicle_id"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("registered_vehicles")
+		},
+	},
+	{
+		// Visits from before invoicing get their invoice when it is first opened
+		Version: 8,
+		Name:    "add_invoices",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&invoice0008{}, &invoiceItem0008{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("invoice_items", "invoices")
+		},
+	},
+	{
+		// The unique index is only added once every visit has a token
+		Version: 9,
+		Name:    "add_vehicle_tracking_tokens",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&vehicle0009{}, "TrackingToken"); err != nil {
+				return err
+			}
+			if err := fillTrackingTokens(tx); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&vehicle0009{}, "TrackingToken")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&vehicle0009{}, "TrackingToken"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&vehicle0009{}, "TrackingToken")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_notifications",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&messageTemplate0010{}, &notification0010{}); err != nil {
+				return err
+			}
+			return seedMessageTemplates(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("notifications", "message_templates")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_webhooks",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&webhook0011{}, &webhookDelivery0011{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("webhook_deliveries", "webhooks")
+		},
+	},
+	{
+		// Tokens issued before sessions carry no session and stop working
+		Version: 12,
+		Name:    "add_sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&session0012{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("sessions")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_login_protection",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&user0013{}, &loginAttempt0013{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("login_attempts"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&user0013{}, "locked_until"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&user0013{}, "failed_logins")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add_invites_and_password_resets",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&user0014{}, &invite0014{}, &passwordReset0014{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("password_resets", "invites"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&user0014{}, "email")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add_two_factor",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&user0015{}, &recoveryCode0015{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("recovery_codes"); err != nil {
+				return err
+			}
+			for _, column := range []string{"totp_last_step", "totp_enabled_at", "totp_secret"} {
+				if err := tx.Migrator().DropColumn(&user0015{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Messages left unsent count as claimed at their last change, so the
+		// retry loop picks them up once the lease has run out
+		Version: 16,
+		Name:    "add_notification_claims",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&notification0016{}, "ClaimedAt"); err != nil {
+				return err
+			}
+			return tx.Table("notifications").
+				Where("status IN ?", []string{"pending", "sending"}).
+				Update("claimed_at", gorm.Expr("updated_at")).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&notification0016{}, "ClaimedAt")
+		},
+	},
+	{
+		// Refresh tokens are replaced on every use, the old ones are kept to
+		// spot a copied token coming back
+		Version: 17,
+		Name:    "add_retired_refresh_tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&retiredRefreshToken0017{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("retired_refresh_tokens")
+		},
+	},
+}
+
+// The baseline types describe the schema as migration 3 creates it. They are
+// frozen copies of the models; later changes go in new migrations.
+
+type baselineUser struct {
+	ID        uint   `gorm:"primary_key"`
+	Username  string `gorm:"unique"`
+	Password  string
+	Role      string `gorm:"not null;default:viewer"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineBay struct {
+	ID             uint   `gorm:"primaryKey"`
+	Name           string `gorm:"unique"`
+	VehicleClasses string
+	Active         bool
+}
+
+func (baselineBay) TableName() string { return "bays" }
+
+type baselineVehicle struct {
+	ID            string
+	UserID        uint
+	User          baselineUser `gorm:"foreignKey:UserID"`
+	BayID         *uint
+	Queue         int `gorm:"uniqueIndex:idx_vehicles_date_queue"`
+	Name          string
+	Package       string
+	Plate         string
+	Process       string
+	Contact       string
+	Date          time.Time `gorm:"uniqueIndex:idx_vehicles_date_queue"`
+	EnterTime     time.Time
+	EstimatedTime time.Time
+	FinishTime    *time.Time
+}
+
+func (baselineVehicle) TableName() string { return "vehicles" }
+
+type baselinePackage struct {
+	ID           uint   `gorm:"primaryKey"`
+	Name         string `gorm:"unique"`
+	Duration     int
+	Price        int64
+	VehicleClass string
+	Active       bool
+}
+
+func (baselinePackage) TableName() string { return "packages" }
+
+type baselineVehicleEvent struct {
+	ID          uint   `gorm:"primaryKey"`
+	VehicleID   string `gorm:"index"`
+	ActorID     uint
+	Type        string
+	FromProcess string
+	ToProcess   string
+	Note        string
+	CreatedAt   time.Time
+}
+
+func (baselineVehicleEvent) TableName() string { return "vehicle_events" }
+
+type baselineSequence struct {
+	Name  string `gorm:"primaryKey"`
+	Value int64
+}
+
+func (baselineSequence) TableName() string { return "sequences" }
+
+type customer0006 struct {
+	ID        uint `gorm:"primaryKey"`
+	Name      string
+	Phone     string `gorm:"uniqueIndex"`
+	Email     string
+	Notes     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (customer0006) TableName() string { return "customers" }
+
+type vehicle0006 struct {
+	CustomerID *uint `gorm:"index"`
+}
+
+func (vehicle0006) TableName() string { return "vehicles" }
+
+// linkVehicleCustomers creates a customer for every distinct phone number in
+// the vehicle contacts and links the vehicles to it
+func linkVehicleCustomers(tx *gorm.DB) error {
+	var vehicles []struct {
+		ID      string
+		Name    string
+		Contact string
+	}
+	err := tx.Table("vehicles").
+		Select("id, name, contact").
+		Where("customer_id IS NULL AND contact <> ''").
+		Order("enter_time DESC").
+		Scan(&vehicles).Error
+	if err != nil {
+		return err
+	}
+
+	customers := map[string]uint{}
+	for _, vehicle := range vehicles {
+		phone := normalizePhone0006(vehicle.Contact)
+		if phone == "" {
+			continue
+		}
+		id, ok := customers[phone]
+		if !ok {
+			// Newest first, so the customer gets the name used on the latest visit
+			customer := customer0006{Name: vehicle.Name, Phone: phone}
+			if err := tx.Where(customer0006{Phone: phone}).FirstOrCreate(&customer).Error; err != nil {
+				return err
+			}
+			id = customer.ID
+			customers[phone] = id
+		}
+		if err := tx.Table("vehicles").Where("id = ?", vehicle.ID).Update("customer_id", id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type registeredVehicle0007 struct {
+	ID           uint   `gorm:"primaryKey"`
+	Plate        string `gorm:"uniqueIndex"`
+	Make         string
+	Model        string
+	Color        string
+	VehicleClass string
+	CustomerID   *uint
+	LastPackage  string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (registeredVehicle0007) TableName() string { return "registered_vehicles" }
+
+type vehicle0007 struct {
+	RegisteredVehicleID *uint `gorm:"index"`
+}
+
+func (vehicle0007) TableName() string { return "vehicles" }
+
+// linkRegisteredVehicles registers every distinct plate washed so far and
+// links its visits to it, remembering the customer and package of the latest one
+func linkRegisteredVehicles(tx *gorm.DB) error {
+	var vehicles []struct {
+		ID         string
+		Plate      string
+		Package    string
+		CustomerID *uint
+	}
+	err := tx.Table("vehicles").
+		Select("id, plate, package, customer_id").
+		Where("registered_vehicle_id IS NULL AND plate <> ''").
+		Order("enter_time DESC").
+		Scan(&vehicles).Error
+	if err != nil {
+		return err
+	}
+
+	var packages []baselinePackage
+	if err := tx.Find(&packages).Error; err != nil {
+		return err
+	}
+	classes := map[string]string{}
+	for _, pkg := range packages {
+		classes[pkg.Name] = pkg.VehicleClass
+	}
+
+	registered := map[string]uint{}
+	for _, vehicle := range vehicles {
+		plate := normalizePlate0007(vehicle.Plate)
+		if plate == "" {
+			continue
+		}
+		id, ok := registered[plate]
+		if !ok {
+			// Newest first, so the registry remembers the latest visit
+			entry := registeredVehicle0007{
+				Plate:        plate,
+				VehicleClass: classes[vehicle.Package],
+				CustomerID:   vehicle.CustomerID,
+				LastPackage:  vehicle.Package,
+			}
+			if err := tx.Where(registeredVehicle0007{Plate: plate}).FirstOrCreate(&entry).Error; err != nil {
+				return err
+			}
+			id = entry.ID
+			registered[plate] = id
+		}
+		if err := tx.Table("vehicles").Where("id = ?", vehicle.ID).Update("registered_vehicle_id", id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type invoice0008 struct {
+	ID            uint   `gorm:"primaryKey"`
+	Number        string `gorm:"uniqueIndex"`
+	VehicleID     string `gorm:"uniqueIndex"`
+	Subtotal      int64
+	Discount      int64
+	DiscountRate  float64
+	DiscountNote  string
+	TaxPercent    float64
+	Tax           int64
+	Total         int64
+	Status        string `gorm:"index"`
+	PaymentMethod string
+	Reference     string
+	PaidAt        *time.Time
+	PaidByID      *uint
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (invoice0008) TableName() string { return "invoices" }
+
+type invoiceItem0008 struct {
+	ID          uint `gorm:"primaryKey"`
+	InvoiceID   uint `gorm:"index"`
+	Kind        string
+	Description string
+	Quantity    int
+	UnitPrice   int64
+	Amount      int64
+}
+
+func (invoiceItem0008) TableName() string { return "invoice_items" }
+
+type vehicle0009 struct {
+	TrackingToken string `gorm:"uniqueIndex"`
+}
+
+func (vehicle0009) TableName() string { return "vehicles" }
+
+// fillTrackingTokens gives every existing visit its own tracking token
+func fillTrackingTokens(tx *gorm.DB) error {
+	var ids []string
+	if err := tx.Table("vehicles").Where("tracking_token IS NULL OR tracking_token = ''").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		token, err := trackingToken0009()
+		if err != nil {
+			return err
+		}
+		if err := tx.Table("vehicles").Where("id = ?", id).Update("tracking_token", token).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type messageTemplate0010 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Process   string `gorm:"uniqueIndex"`
+	Body      string
+	Enabled   bool
+	UpdatedAt time.Time
+}
+
+func (messageTemplate0010) TableName() string { return "message_templates" }
+
+type notification0010 struct {
+	ID        uint   `gorm:"primaryKey"`
+	VehicleID string `gorm:"index"`
+	Process   string
+	Recipient string
+	Message   string
+	Status    string `gorm:"index"`
+	Attempts  int
+	Error     string
+	Reference string
+	SentAt    *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (notification0010) TableName() string { return "notifications" }
+
+// seedMessageTemplates adds a message for each process customers may want to
+// hear about. Only the "ready for pickup" message is sent until staff enable more.
+func seedMessageTemplates(tx *gorm.DB) error {
+	templates := []messageTemplate0010{
+		{Process: "Washing", Body: "Hi {name}, your vehicle {plate} (queue {queue}) is being washed at {business}. Estimated ready at {eta}. Follow it here: {track_url}"},
+		{Process: "Finish", Body: "Hi {name}, your vehicle {plate} is ready for pickup at {business}. Thank you!", Enabled: true},
+		{Process: "Cancelled", Body: "Hi {name}, the wash of your vehicle {plate} at {business} has been cancelled. Please see our staff."},
+	}
+	return tx.Create(&templates).Error
+}
+
+type webhook0011 struct {
+	ID        uint `gorm:"primaryKey"`
+	URL       string
+	Secret    string
+	Events    string
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (webhook0011) TableName() string { return "webhooks" }
+
+type webhookDelivery0011 struct {
+	ID             uint `gorm:"primaryKey"`
+	WebhookID      uint `gorm:"index"`
+	Event          string
+	Payload        string
+	Status         string `gorm:"index"`
+	Attempts       int
+	ResponseStatus int
+	Error          string
+	NextAttemptAt  *time.Time
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (webhookDelivery0011) TableName() string { return "webhook_deliveries" }
+
+type session0012 struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	TokenHash  string `gorm:"uniqueIndex"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+func (session0012) TableName() string { return "sessions" }
+
+type user0013 struct {
+	FailedLogins int `gorm:"not null;default:0"`
+	LockedUntil  *time.Time
+}
+
+func (user0013) TableName() string { return "users" }
+
+type loginAttempt0013 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Username  string `gorm:"index"`
+	UserID    *uint  `gorm:"index"`
+	IP        string `gorm:"index"`
+	UserAgent string
+	Outcome   string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (loginAttempt0013) TableName() string { return "login_attempts" }
+
+type user0014 struct {
+	Email string
+}
+
+func (user0014) TableName() string { return "users" }
+
+type invite0014 struct {
+	ID          uint `gorm:"primaryKey"`
+	Email       string
+	Role        string
+	TokenHash   string `gorm:"uniqueIndex"`
+	CreatedByID uint
+	ExpiresAt   time.Time
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
+
+func (invite0014) TableName() string { return "invites" }
+
+type passwordReset0014 struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index"`
+	TokenHash   string `gorm:"uniqueIndex"`
+	CreatedByID uint
+	ExpiresAt   time.Time
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
+
+func (passwordReset0014) TableName() string { return "password_resets" }
+
+type user0015 struct {
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	TOTPLastStep  int64 `gorm:"not null;default:0"`
+}
+
+func (user0015) TableName() string { return "users" }
+
+type recoveryCode0015 struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	CodeHash  string `gorm:"index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (recoveryCode0015) TableName() string { return "recovery_codes" }
+
+type notification0016 struct {
+	ClaimedAt *time.Time
+}
+
+func (notification0016) TableName() string { return "notifications" }
+
+type retiredRefreshToken0017 struct {
+	ID        uint   `gorm:"primaryKey"`
+	SessionID uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	RetiredAt time.Time
+}
+
+func (retiredRefreshToken0017) TableName() string { return "retired_refresh_tokens" }
+
+// hasLegacyVehicleTimes reports whether the vehicle times are still stored as formatted strings
+func hasLegacyVehicleTimes(tx *gorm.DB) bool {
+	if !tx.Migrator().HasTable("vehicles") {
+		return false
+	}
+	columnTypes, err := tx.Migrator().ColumnTypes("vehicles")
+	if err != nil {
+		return false
+	}
+	for _, column := range columnTypes {
+		if column.Name() == "enter_time" {
+			// Timestamps are datetime, timestamp or timestamptz depending on the database
+			typeName := strings.ToLower(column.DatabaseTypeName())
+			return strings.Contains(typeName, "char") || strings.Contains(typeName, "text")
+		}
+	}
+	return false
+}
+
+// seedPackages fills an empty catalog with the packages that used to be hardcoded
+func seedPackages(tx *gorm.DB) error {
+	var count int64
+	if err := tx.Model(&baselinePackage{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	packages := []baselinePackage{
+		{Name: "Motor", Duration: 25, Price: 15000, VehicleClass: "Motor", Active: true},
+		{Name: "Motor Besar", Duration: 30, Price: 20000, VehicleClass: "Motor Besar", Active: true},
+		{Name: "Mobil", Duration: 40, Price: 35000, VehicleClass: "Mobil", Active: true},
+		{Name: "Mobil Besar", Duration: 50, Price: 45000, VehicleClass: "Mobil Besar", Active: true},
+		{Name: "Cuci Luar Mobil", Duration: 40, Price: 25000, VehicleClass: "Mobil", Active: true},
+	}
+	return tx.Create(&packages).Error
+}
+
+// seedBays creates a single bay for every vehicle class so washing works out of the box
+func seedBays(tx *gorm.DB) error {
+	var count int64
+	if err := tx.Model(&baselineBay{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	bay := baselineBay{
+		Name:           "Bay 1",
+		VehicleClasses: strings.Join(vehicleClasses0005, ","),
+		Active:         true,
+	}
+	return tx.Create(&bay).Error
+}
+
+// convertLegacyVehicleTimes rewrites the date and clock strings of every
+// vehicle as UTC timestamps, reading them in the business time zone.
+func convertLegacyVehicleTimes(tx *gorm.DB) error {
+	var rows []struct {
+		ID            string
+		Date          string
+		EnterTime     string
+		EstimatedTime string
+		FinishTime    string
+	}
+	err := tx.Table("vehicles").
+		Select("id, COALESCE(date, '') AS date, COALESCE(enter_time, '') AS enter_time, COALESCE(estimated_time, '') AS estimated_time, COALESCE(finish_time, '') AS finish_time").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		day, err := time.ParseInLocation("2006-01-02", row.Date, BusinessLocation)
+		if err != nil {
+			// Already converted or unreadable, leave the row alone
+			continue
+		}
+		enterTime := legacyClock(day, row.EnterTime, day)
+		updates := map[string]interface{}{
+			"date":           day.UTC(),
+			"enter_time":     enterTime.UTC(),
+			"estimated_time": legacyClock(day, row.EstimatedTime, enterTime).UTC(),
+			"finish_time":    nil,
+		}
+		if row.FinishTime != "" {
+			updates["finish_time"] = legacyClock(day, row.FinishTime, enterTime).UTC()
+		}
+		if err := tx.Table("vehicles").Where("id = ?", row.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Converted times of %d vehicles", len(rows))
+	return nil
+}
+
+// legacyClock places a "3:04 PM" clock time on the given day, rolling over
+// to the next day when it would fall before notBefore.
+func legacyClock(day time.Time, clock string, notBefore time.Time) time.Time {
+	parsed, err := time.Parse("3:04 PM", clock)
+	if err != nil {
+		return notBefore
+	}
+	t := time.Date(day.Year(), day.Month(), day.Day(), parsed.Hour(), parsed.Minute(), 0, 0, day.Location())
+	if t.Before(notBefore) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// renumberDuplicateQueues moves every vehicle sharing a queue number with an
+// earlier vehicle of the same day to the end of that day's queue. Old
+// databases still keep the date in a text column, so dates are only compared
+// with each other as read, never with a parsed time.
+func renumberDuplicateQueues(tx *gorm.DB) error {
+	var rows []struct {
+		ID    string
+		Date  string
+		Queue int
+	}
+	if err := tx.Table("vehicles").Select("id, date, queue").Order("enter_time, id").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	lastQueue := make(map[string]int)
+	for _, row := range rows {
+		if row.Queue > lastQueue[row.Date] {
+			lastQueue[row.Date] = row.Queue
+		}
+	}
+	taken := make(map[string]bool)
+	for _, row := range rows {
+		slot := row.Date + "#" + strconv.Itoa(row.Queue)
+		if !taken[slot] {
+			taken[slot] = true
+			continue
+		}
+		lastQueue[row.Date]++
+		if err := tx.Table("vehicles").Where("id = ?", row.ID).Update("queue", lastQueue[row.Date]).Error; err != nil {
+			return err
+		}
+		taken[row.Date+"#"+strconv.Itoa(lastQueue[row.Date])] = true
+	}
+	return nil
+}
+
+// seedSequences starts the ID and queue counters after the numbers already in use
+func seedSequences(tx *gorm.DB) error {
+	var count int64
+	if err := tx.Model(&baselineSequence{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var vehicles []struct {
+		ID       string
+		UserID   uint
+		Username string
+		Date     time.Time
+		Queue    int
+	}
+	err := tx.Table("vehicles").
+		Select("vehicles.id, vehicles.user_id, users.username, vehicles.date, vehicles.queue").
+		Joins("LEFT JOIN users ON users.id = vehicles.user_id").
+		Scan(&vehicles).Error
+	if err != nil {
+		return err
+	}
+	values := map[string]int64{}
+	for _, vehicle := range vehicles {
+		queueName := queueSequence0005(vehicle.Date)
+		if int64(vehicle.Queue) > values[queueName] {
+			values[queueName] = int64(vehicle.Queue)
+		}
+
+		// IDs look like username-N
+		suffix := strings.TrimPrefix(vehicle.ID, vehicle.Username+"-")
+		if n, err := strconv.ParseInt(suffix, 10, 64); err == nil {
+			vehicleName := vehicleSequence0005(vehicle.UserID)
+			if n > values[vehicleName] {
+				values[vehicleName] = n
+			}
+		}
+	}
+
+	for name, value := range values {
+		if err := tx.Create(&baselineSequence{Name: name, Value: value}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertLegacyUserAdmin turns the admin flag into roles. Admins become
+// admins, other users keep checking vehicles in as cashiers, and the oldest
+// admin (or user) becomes the owner. The admin column is dropped afterwards.
+func convertLegacyUserAdmin(tx *gorm.DB) error {
+	if err := tx.Exec("UPDATE users SET role = ? WHERE admin = ?", roleAdmin0004, true).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("UPDATE users SET role = ? WHERE admin = ? OR admin IS NULL", roleCashier0004, false).Error; err != nil {
+		return err
+	}
+
+	var owner baselineUser
+	err := tx.Where("role = ?", roleAdmin0004).Order("id").First(&owner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = tx.Order("id").First(&owner).Error
+	}
+	if err == nil {
+		if err := tx.Model(&owner).Update("role", roleOwner0004).Error; err != nil {
+			return err
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return tx.Migrator().DropColumn(&baselineUser{}, "admin")
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/middleware"
+	"nevacarwash.com/main/services"
+)
+
+type AccountHandler struct {
+	service   *services.AccountService
+	users     *services.UserService
+	twoFactor *services.TwoFactorService
+}
+
+func NewAccountHandler(service *services.AccountService, users *services.UserService, twoFactor *services.TwoFactorService) *AccountHandler {
+	return &AccountHandler{service: service, users: users, twoFactor: twoFactor}
+}
+
+// Register creates an account from an invite link. Only the very first
+// account, which owns the shop, can be created without one.
+func (h *AccountHandler) Register(c *gin.Context) {
+	// Keep the token in the address out of requests to other sites
+	c.Header("Referrer-Policy", "no-referrer")
+	token := c.Query("invite")
+	if c.Request.Method == http.MethodPost {
+		token = c.PostForm("invite")
+	}
+
+	needsOwner, err := h.service.NeedsOwner()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "register.html", gin.H{"Error": err.Error()})
+		return
+	}
+	data := gin.H{"Rules": services.PasswordRules, "Owner": needsOwner, "Invite": token}
+	if !needsOwner {
+		invite, err := h.service.GetInvite(token)
+		if err != nil {
+			if token == "" {
+				err = services.ErrInviteRequired
+			}
+			c.HTML(accountErrorStatus(err), "register.html", gin.H{"Error": err.Error(), "Closed": true})
+			return
+		}
+		data["Email"] = invite.Email
+		data["Role"] = invite.Role
+	}
+
+	if c.Request.Method == http.MethodGet {
+		c.HTML(http.StatusOK, "register.html", data)
+		return
+	}
+
+	var input services.RegisterInput
+	if err := c.ShouldBind(&input); err != nil {
+		data["Error"] = err.Error()
+		c.HTML(http.StatusBadRequest, "register.html", data)
+		return
+	}
+	if needsOwner {
+		err = h.service.RegisterOwner(input)
+	} else {
+		err = h.service.Register(token, input)
+	}
+	if err != nil {
+		data["Error"] = err.Error()
+		data["Username"] = input.Username
+		c.HTML(accountErrorStatus(err), "register.html", data)
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{"Success": "Account created, you can log in now"})
+}
+
+// GetAccount shows the signed in user's email address and password forms
+func (h *AccountHandler) GetAccount(c *gin.Context) {
+	h.renderAccount(c, http.StatusOK, gin.H{})
+}
+
+// UpdateEmail changes where the user's reset links are sent
+func (h *AccountHandler) UpdateEmail(c *gin.Context) {
+	if err := h.service.UpdateEmail(currentUserID(c), c.PostForm("email")); err != nil {
+		h.renderAccount(c, accountErrorStatus(err), gin.H{"Error": err.Error()})
+		return
+	}
+	h.renderAccount(c, http.StatusOK, gin.H{"Success": "Email address saved"})
+}
+
+// ChangePassword sets a new password, signing the user out on their other devices
+func (h *AccountHandler) ChangePassword(c *gin.Context) {
+	var input services.PasswordInput
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderAccount(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	err := h.service.ChangePassword(currentUserID(c), middleware.CurrentSessionID(c), input)
+	if err != nil {
+		h.renderAccount(c, accountErrorStatus(err), gin.H{"Error": err.Error()})
+		return
+	}
+	h.renderAccount(c, http.StatusOK, gin.H{"Success": "Password changed, your other devices have been signed out"})
+}
+
+// ResetPassword lets a user pick a new password through a reset link
+func (h *AccountHandler) ResetPassword(c *gin.Context) {
+	c.Header("Referrer-Policy", "no-referrer")
+	token := c.Param("token")
+	reset, err := h.service.GetPasswordReset(token)
+	if err != nil {
+		c.HTML(accountErrorStatus(err), "reset.html", gin.H{"Error": err.Error(), "Closed": true})
+		return
+	}
+	data := gin.H{"Token": token, "Username": reset.User.Username, "Rules": services.PasswordRules}
+
+	if c.Request.Method == http.MethodGet {
+		c.HTML(http.StatusOK, "reset.html", data)
+		return
+	}
+
+	var input services.PasswordInput
+	if err := c.ShouldBind(&input); err != nil {
+		data["Error"] = err.Error()
+		c.HTML(http.StatusBadRequest, "reset.html", data)
+		return
+	}
+	if err := h.service.ResetPassword(token, input); err != nil {
+		data["Error"] = err.Error()
+		c.HTML(accountErrorStatus(err), "reset.html", data)
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{"Success": "Password changed, you can log in with it now"})
+}
+
+// SetupTwoFactor shows the authenticator secret as a QR code, and turns
+// two-factor authentication on once a code from it is entered
+func (h *AccountHandler) SetupTwoFactor(c *gin.Context) {
+	userID := currentUserID(c)
+	data := gin.H{"Action": "/account/2fa"}
+
+	if c.Request.Method == http.MethodGet {
+		enrollment, err := h.twoFactor.ResumeEnrollment(userID)
+		if err != nil {
+			h.renderAccount(c, accountErrorStatus(err), gin.H{"Error": err.Error()})
+			return
+		}
+		addEnrollment(data, enrollment)
+		c.HTML(http.StatusOK, "two_factor.html", data)
+		return
+	}
+
+	codes, err := h.twoFactor.ConfirmEnrollment(userID, c.PostForm("code"))
+	if err != nil {
+		enrollment, enrollErr := h.twoFactor.GetEnrollment(userID)
+		if enrollErr != nil {
+			h.renderAccount(c, accountErrorStatus(enrollErr), gin.H{"Error": enrollErr.Error()})
+			return
+		}
+		addEnrollment(data, enrollment)
+		data["Error"] = err.Error()
+		c.HTML(accountErrorStatus(err), "two_factor.html", data)
+		return
+	}
+	c.HTML(http.StatusOK, "two_factor.html", gin.H{"Codes": codes, "Next": "/account"})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with new ones
+func (h *AccountHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(currentUserID(c), c.PostForm("password"), c.PostForm("code"))
+	if err != nil {
+		h.renderAccount(c, accountErrorStatus(err), gin.H{"Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "two_factor.html", gin.H{"Codes": codes, "Next": "/account"})
+}
+
+// DisableTwoFactor turns two-factor authentication off, for roles that do not require it
+func (h *AccountHandler) DisableTwoFactor(c *gin.Context) {
+	if err := h.twoFactor.Disable(currentUserID(c), c.PostForm("password"), c.PostForm("code")); err != nil {
+		h.renderAccount(c, accountErrorStatus(err), gin.H{"Error": err.Error()})
+		return
+	}
+	h.renderAccount(c, http.StatusOK, gin.H{"Success": "Two-factor authentication turned off"})
+}
+
+func (h *AccountHandler) renderAccount(c *gin.Context, status int, data gin.H) {
+	user, err := h.users.GetUserByID(currentUserID(c))
+	if err != nil {
+		c.HTML(errorStatus(err), "account.html", gin.H{"Error": err.Error()})
+		return
+	}
+	data["User"] = user
+	data["Rules"] = services.PasswordRules
+	if user.TwoFactorEnabled() {
+		data["RecoveryCodesLeft"], err = h.twoFactor.RecoveryCodesLeft(user.ID)
+		if msg, _ := data["Error"].(string); err != nil && msg == "" {
+			data["Error"] = err.Error()
+			status = http.StatusInternalServerError
+		}
+	}
+	c.HTML(status, "account.html", data)
+}
+
+// addEnrollment adds what the two-factor page shows to set up an authenticator
+func addEnrollment(data gin.H, enrollment *services.TwoFactorEnrollment) {
+	data["Secret"] = enrollment.Secret
+	data["QRCode"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(enrollment.QRCode))
+}
+
+// accountErrorStatus maps account errors to the HTTP status they should be reported with
+func accountErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrWeakPassword), errors.Is(err, services.ErrPasswordMismatch),
+		errors.Is(err, services.ErrWrongPassword), errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrInvalidCode), errors.Is(err, services.ErrCodeRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrTwoFactorEnabled), errors.Is(err, services.ErrTwoFactorOff):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrUsernameTaken):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInviteRequired):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrInviteInvalid), errors.Is(err, services.ErrResetInvalid):
+		return http.StatusNotFound
+	}
+	return roleErrorStatus(err)
+}
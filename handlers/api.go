@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"nevacarwash.com/main/middleware"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// VehicleAPIHandler serves the versioned JSON API for vehicles
+type VehicleAPIHandler struct {
+	service        *services.VehicleService
+	invoiceService *services.InvoiceService
+}
+
+func NewVehicleAPIHandler(service *services.VehicleService, invoiceService *services.InvoiceService) *VehicleAPIHandler {
+	return &VehicleAPIHandler{service: service, invoiceService: invoiceService}
+}
+
+type vehicleJSON struct {
+	ID            string     `json:"id"`
+	CustomerID    *uint      `json:"customer_id"`
+	RegisteredID  *uint      `json:"registered_vehicle_id"`
+	Queue         int        `json:"queue"`
+	Name          string     `json:"name"`
+	Package       string     `json:"package"`
+	Plate         string     `json:"plate"`
+	Contact       string     `json:"contact"`
+	Process       string     `json:"process"`
+	Bay           string     `json:"bay,omitempty"`
+	CreatedBy     string     `json:"created_by"`
+	Date          string     `json:"date"`
+	EnterTime     time.Time  `json:"enter_time"`
+	EstimatedTime time.Time  `json:"estimated_time"`
+	FinishTime    *time.Time `json:"finish_time"`
+	TrackingToken string     `json:"tracking_token"` // Key of the public page at /track/:token
+}
+
+func toVehicleJSON(vehicle *repositories.Vehicle) vehicleJSON {
+	out := vehicleJSON{
+		ID:            vehicle.ID,
+		CustomerID:    vehicle.CustomerID,
+		RegisteredID:  vehicle.RegisteredVehicleID,
+		Queue:         vehicle.Queue,
+		Name:          vehicle.Name,
+		Package:       vehicle.Package,
+		Plate:         vehicle.Plate,
+		Contact:       vehicle.Contact,
+		Process:       vehicle.Process,
+		CreatedBy:     vehicle.User.Username,
+		TrackingToken: vehicle.TrackingToken,
+		Date:          vehicle.Date.In(repositories.BusinessLocation).Format("2006-01-02"),
+		EnterTime:     vehicle.EnterTime,
+		EstimatedTime: vehicle.EstimatedTime,
+		FinishTime:    vehicle.FinishTime,
+	}
+	if vehicle.Bay != nil {
+		out.Bay = vehicle.Bay.Name
+	}
+	return out
+}
+
+// apiError writes the error body shared by every API endpoint
+func apiError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": gin.H{
+		"code":    strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+		"message": message,
+	}})
+}
+
+// apiServiceError reports a service error with the status it maps to
+func apiServiceError(c *gin.Context, err error) {
+	apiError(c, errorStatus(err), err.Error())
+}
+
+func (h *VehicleAPIHandler) ListVehicles(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		apiError(c, http.StatusBadRequest, "page must be a positive number")
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPageSize)))
+	if err != nil || perPage < 1 || perPage > maxPageSize {
+		apiError(c, http.StatusBadRequest, "per_page must be between 1 and "+strconv.Itoa(maxPageSize))
+		return
+	}
+
+	filter := repositories.VehicleFilter{
+		Process: c.Query("process"),
+		Plate:   c.Query("plate"),
+		Offset:  (page - 1) * perPage,
+		Limit:   perPage,
+	}
+	if value := c.Query("date"); value != "" {
+		date, err := repositories.ParseBusinessDate(value)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "date must look like 2006-01-02")
+			return
+		}
+		filter.Date = &date
+	}
+
+	vehicles, total, err := h.service.SearchVehicles(filter)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+
+	data := make([]vehicleJSON, 0, len(vehicles))
+	for i := range vehicles {
+		data = append(data, toVehicleJSON(&vehicles[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":     data,
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
+
+func (h *VehicleAPIHandler) GetVehicle(c *gin.Context) {
+	vehicle, err := h.service.GetVehicleByID(c.Param("id"))
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toVehicleJSON(vehicle))
+}
+
+func (h *VehicleAPIHandler) CreateVehicle(c *gin.Context) {
+	var input repositories.CreateVehicleRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apiError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	input.UID = strconv.FormatUint(uint64(currentUserID(c)), 10)
+
+	id, err := h.service.CreateVehicle(&input)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	vehicle, err := h.service.GetVehicleByID(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, toVehicleJSON(vehicle))
+}
+
+func (h *VehicleAPIHandler) UpdateVehicle(c *gin.Context) {
+	id := c.Param("id")
+	var input repositories.CreateVehicleRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apiError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.UpdateVehicle(id, input, currentUserID(c)); err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	vehicle, err := h.service.GetVehicleByID(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toVehicleJSON(vehicle))
+}
+
+func (h *VehicleAPIHandler) DeleteVehicle(c *gin.Context) {
+	id := c.Param("id")
+	vehicle, err := h.service.GetVehicleByID(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+
+	if !canDeleteVehicle(c, vehicle) {
+		apiError(c, http.StatusForbidden, "Not authorized to delete this vehicle")
+		return
+	}
+
+	if err := h.service.DeleteVehicle(id); err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type transitionJSON struct {
+	Process string `json:"process" binding:"required"`
+	BayID   uint   `json:"bay_id"`
+	Reason  string `json:"reason"`
+
+	// Managers may hand over an unpaid vehicle, giving the reason
+	OverridePayment bool `json:"override_payment"`
+}
+
+func (h *VehicleAPIHandler) TransitionVehicle(c *gin.Context) {
+	id := c.Param("id")
+	var input transitionJSON
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apiError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := h.service.TransitionVehicle(id, services.TransitionRequest{
+		Process: input.Process,
+		BayID:   input.BayID,
+		Reason:  input.Reason,
+		ActorID: currentUserID(c),
+
+		OverridePayment: input.OverridePayment && middleware.HasRole(c, managerRoles...),
+	})
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	vehicle, err := h.service.GetVehicleByID(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, toVehicleJSON(vehicle))
+}
+
+func (h *VehicleAPIHandler) GetVehicleEvents(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.service.GetVehicleByID(id); err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	events, err := h.service.GetVehicleEvents(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": eventTimeline(events)})
+}
+
+func (h *VehicleAPIHandler) GetInvoice(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.service.GetVehicleByID(id); err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	invoice, err := h.invoiceService.GetInvoice(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": invoice})
+}
+
+// NotFound answers unknown API routes with the usual error body
+func NotFound(c *gin.Context) {
+	if middleware.IsAPIRequest(c) {
+		apiError(c, http.StatusNotFound, "Route not found")
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+// isNotFound reports whether err means the record does not exist
+func isNotFound(err error) bool {
+	return errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, services.ErrNoInvoice)
+}
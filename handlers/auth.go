@@ -1,18 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"os"
-	"time"
-	"strings"
+	"strconv"
 
-	"nevacarwash.com/main/database"
+	"nevacarwash.com/main/middleware"
 	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func Home(c *gin.Context) {
@@ -23,108 +21,232 @@ func Home(c *gin.Context) {
 	}
 	c.Next() // Proceed with the next handler
 }
-func CreateUser(c *gin.Context) {
+
+type AuthHandler struct {
+	logins    *services.LoginService
+	sessions  *services.SessionService
+	twoFactor *services.TwoFactorService
+}
+
+func NewAuthHandler(logins *services.LoginService, sessions *services.SessionService, twoFactor *services.TwoFactorService) *AuthHandler {
+	return &AuthHandler{logins: logins, sessions: sessions, twoFactor: twoFactor}
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
 	if c.Request.Method == http.MethodGet {
-		c.HTML(http.StatusOK, "register.html", nil)
+		c.HTML(http.StatusOK, "login.html", nil)
 		return
 	}
-
 	var authInput repositories.AuthInput
 
 	if err := c.ShouldBind(&authInput); err != nil {
-		c.HTML(http.StatusOK, "register.html", gin.H{"Error": err.Error()})
+		c.HTML(http.StatusOK, "login.html", gin.H{"Error": err.Error()})
 		return
 	}
 
-	var userFound repositories.User
-	database.GetDB().Where("username=?", authInput.Username).Find(&userFound)
+	user, err := h.logins.Authenticate(authInput.Username, authInput.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.HTML(loginErrorStatus(c, err), "login.html", gin.H{"Error": err.Error()})
+		return
+	}
+
+	// Two-factor users continue on the code page before they get a session
+	if services.NeedsSecondFactor(user) {
+		token, expiresAt, err := h.twoFactor.IssueLoginChallenge(user)
+		if err != nil {
+			c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Error generating token"})
+			return
+		}
+		middleware.SetLoginChallenge(c, token, expiresAt)
+		c.Redirect(http.StatusSeeOther, "/login/2fa")
+		return
+	}
 
-	if userFound.ID != 0 {
-		c.HTML(http.StatusOK, "register.html", gin.H{"Error": "Username already used"})
+	if err := h.signIn(c, user); err != nil {
+		c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Error generating token"})
 		return
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(authInput.Password), bcrypt.DefaultCost)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// LoginCode is the second login step. It asks for an authenticator code, or
+// has a user whose role requires two-factor authentication set it up first.
+func (h *AuthHandler) LoginCode(c *gin.Context) {
+	user, err := h.twoFactor.LoginChallengeUser(middleware.LoginChallenge(c))
 	if err != nil {
-		c.HTML(http.StatusOK, "register.html", gin.H{"Error": "Failed to hash password"})
+		middleware.ClearLoginChallenge(c)
+		c.HTML(loginErrorStatus(c, err), "login.html", gin.H{"Error": err.Error()})
 		return
 	}
+	data := gin.H{"Action": "/login/2fa", "Username": user.Username}
+	enroll := !user.TwoFactorEnabled()
 
-	user := repositories.User{
-		Username: authInput.Username,
-		Password: string(passwordHash),
-		Admin:    strings.Contains(authInput.Username, "@admin"), // Set Admin to true if username contains '@admin'
+	if c.Request.Method == http.MethodGet {
+		if enroll {
+			enrollment, err := h.twoFactor.ResumeEnrollment(user.ID)
+			if err != nil {
+				c.HTML(http.StatusInternalServerError, "login.html", gin.H{"Error": err.Error()})
+				return
+			}
+			addEnrollment(data, enrollment)
+		}
+		c.HTML(http.StatusOK, "two_factor.html", data)
+		return
 	}
 
-	database.GetDB().Create(&user)
+	var codes []string
+	if enroll {
+		codes, err = h.logins.CompleteEnrollment(user, c.PostForm("code"), c.ClientIP(), c.Request.UserAgent())
+	} else {
+		err = h.logins.VerifyCode(user, c.PostForm("code"), c.ClientIP(), c.Request.UserAgent())
+	}
+	if err != nil {
+		status := loginErrorStatus(c, err)
+		if enroll {
+			enrollment, err := h.twoFactor.GetEnrollment(user.ID)
+			if err != nil {
+				middleware.ClearLoginChallenge(c)
+				c.HTML(http.StatusInternalServerError, "login.html", gin.H{"Error": err.Error()})
+				return
+			}
+			addEnrollment(data, enrollment)
+		}
+		data["Error"] = err.Error()
+		c.HTML(status, "two_factor.html", data)
+		return
+	}
 
-	c.HTML(http.StatusOK, "login.html", gin.H{"Success": "User created successfully"})
+	middleware.ClearLoginChallenge(c)
+	if err := h.signIn(c, user); err != nil {
+		c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Error generating token"})
+		return
+	}
+	if enroll {
+		c.HTML(http.StatusOK, "two_factor.html", gin.H{"Codes": codes, "Next": "/"})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/")
 }
 
-func Login(c *gin.Context) {
-	if c.Request.Method == http.MethodGet {
-		c.HTML(http.StatusOK, "login.html", nil)
-		return
+// signIn starts a session for the browser
+func (h *AuthHandler) signIn(c *gin.Context, user *repositories.User) error {
+	tokens, err := h.sessions.StartSession(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return err
 	}
-	var authInput repositories.AuthInput
+	middleware.SetSessionCookies(c, tokens)
+	middleware.RenewCSRFToken(c)
+	return nil
+}
 
-	if err := c.ShouldBind(&authInput); err != nil {
-		c.HTML(http.StatusOK, "login.html", gin.H{"Error": err.Error()})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+// APILogin exchanges a username and password for a short lived bearer token
+// and a refresh token to renew it with
+func (h *AuthHandler) APILogin(c *gin.Context) {
+	var authInput repositories.AuthInput
+	if err := c.ShouldBindJSON(&authInput); err != nil {
+		apiError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var userFound repositories.User
-	database.GetDB().Where("username = ?", authInput.Username).First(&userFound)
-	log.Println("authInput.Username:", authInput.Username)
-	if userFound.ID == 0 {
-		c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Invalid username or password"})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid username or password"})
+	user, err := h.logins.Authenticate(authInput.Username, authInput.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		apiError(c, loginErrorStatus(c, err), err.Error())
 		return
 	}
+	// API clients send the code along with the password, authenticators are
+	// only set up on the website
+	if services.NeedsSecondFactor(user) {
+		if !user.TwoFactorEnabled() {
+			apiError(c, http.StatusForbidden, services.ErrTwoFactorSetup.Error())
+			return
+		}
+		if authInput.Code == "" {
+			apiError(c, http.StatusUnauthorized, services.ErrCodeRequired.Error())
+			return
+		}
+		if err := h.logins.VerifyCode(user, authInput.Code, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			apiError(c, loginErrorStatus(c, err), err.Error())
+			return
+		}
+	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(userFound.Password), []byte(authInput.Password)); err != nil {
-		c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Invalid username or password"})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid username or password"})
+	tokens, err := h.sessions.StartSession(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "Error generating token")
 		return
 	}
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
 
-	log.Println("userFound.id:", userFound.ID)
-	generateToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":       userFound.ID,
-		"username": userFound.Username,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
+// APIRefresh exchanges a refresh token for a new bearer token
+func (h *AuthHandler) APIRefresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apiError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	token, err := generateToken.SignedString([]byte(os.Getenv("SECRET")))
+	tokens, err := h.sessions.Refresh(input.RefreshToken, c.ClientIP())
+	if errors.Is(err, services.ErrSessionExpired) {
+		apiError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
 	if err != nil {
-		c.HTML(http.StatusOK, "login.html", gin.H{"Error": "Error generating token"})
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Error generating token"})
-	}
-	// Set token in cookie
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie(
-		"Authorization", // name
-		token,           // value
-		3600*24,         // max age (24 hours)
-		"",              // path
-		"",              // domain
-		false,           // secure
-		false,           // httpOnly
-	)
+		apiError(c, http.StatusInternalServerError, "Error generating token")
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
 
-	c.Redirect(http.StatusSeeOther, "/")
+// APILogout revokes the session of the bearer token
+func (h *AuthHandler) APILogout(c *gin.Context) {
+	if err := h.sessions.Revoke(currentUserID(c), middleware.CurrentSessionID(c)); err != nil && !isNotFound(err) {
+		apiError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
-func Logout(c *gin.Context) {
-	c.SetCookie(
-		"Authorization",
-		"",    // value
-		-1,    // max age
-		"",    // path
-		"",    // domain
-		false, // secure
-		false, // httpOnly
-	)
+// Logout signs the browser out and revokes its session, so its refresh token stops working
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if userID, sessionID := currentUserID(c), middleware.CurrentSessionID(c); sessionID != 0 {
+		if err := h.sessions.Revoke(userID, sessionID); err != nil && !isNotFound(err) {
+			log.Printf("Failed to revoke session %d: %v", sessionID, err)
+		}
+	}
+	middleware.ClearSessionCookies(c)
 	c.Redirect(http.StatusSeeOther, "/")
 }
+
+// loginErrorStatus maps login errors to the HTTP status they should be
+// reported with, telling throttled clients when to try again
+func loginErrorStatus(c *gin.Context, err error) int {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(throttled.Wait.Seconds())+1))
+		return http.StatusTooManyRequests
+	case errors.Is(err, services.ErrAccountLocked):
+		return http.StatusLocked
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidCode),
+		errors.Is(err, services.ErrChallengeExpired):
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+func tokenResponse(tokens *services.SessionTokens) gin.H {
+	response := gin.H{
+		"token":              tokens.AccessToken,
+		"token_type":         "Bearer",
+		"expires_at":         tokens.AccessExpiresAt,
+		"refresh_expires_at": tokens.ExpiresAt,
+	}
+	if tokens.RefreshToken != "" {
+		response["refresh_token"] = tokens.RefreshToken
+	}
+	return response
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type BayHandler struct {
+	service *services.BayService
+}
+
+func NewBayHandler(service *services.BayService) *BayHandler {
+	return &BayHandler{service: service}
+}
+
+func (h *BayHandler) GetBays(c *gin.Context) {
+	bays, err := h.service.GetBays()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "bays.html", gin.H{"Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "bays.html", gin.H{
+		"Bays": bays,
+	})
+}
+
+func (h *BayHandler) CreateBay(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		c.HTML(http.StatusOK, "bayform.html", gin.H{
+			"Action":         "/bays/new",
+			"Selected":       repositories.VehicleClasses,
+			"Active":         true,
+			"VehicleClasses": repositories.VehicleClasses,
+		})
+		return
+	}
+
+	var input repositories.BayRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "bayform.html", bayFormData("/bays/new", input, err))
+		return
+	}
+
+	if _, err := h.service.CreateBay(&input); err != nil {
+		c.HTML(http.StatusInternalServerError, "bayform.html", bayFormData("/bays/new", input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/bays")
+}
+
+func (h *BayHandler) UpdateBay(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/bays")
+		return
+	}
+	action := "/bays/" + c.Param("id") + "/edit"
+
+	// Show edit form for GET requests
+	if c.Request.Method == http.MethodGet {
+		bay, err := h.service.GetBayByID(uint(id))
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/bays")
+			return
+		}
+		c.HTML(http.StatusOK, "bayform.html", gin.H{
+			"Action":         action,
+			"Name":           bay.Name,
+			"Selected":       bay.Classes(),
+			"Active":         bay.Active,
+			"VehicleClasses": repositories.VehicleClasses,
+		})
+		return
+	}
+
+	var input repositories.BayRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "bayform.html", bayFormData(action, input, err))
+		return
+	}
+
+	if err := h.service.UpdateBay(uint(id), input); err != nil {
+		c.HTML(http.StatusInternalServerError, "bayform.html", bayFormData(action, input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/bays")
+}
+
+func (h *BayHandler) DeleteBay(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/bays")
+		return
+	}
+
+	if err := h.service.DeleteBay(uint(id)); err != nil {
+		c.HTML(http.StatusInternalServerError, "bays.html", gin.H{"Error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/bays")
+}
+
+// bayFormData refills the bay form after a failed submit
+func bayFormData(action string, input repositories.BayRequest, err error) gin.H {
+	return gin.H{
+		"Error":          err.Error(),
+		"Action":         action,
+		"Name":           input.Name,
+		"Selected":       input.VehicleClasses,
+		"Active":         input.Active,
+		"VehicleClasses": repositories.VehicleClasses,
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/services"
+)
+
+// heartbeatInterval keeps idle event streams from being closed by proxies
+const heartbeatInterval = 25 * time.Second
+
+// BoardHandler serves the waiting room TV board and the live event streams
+// that keep it, the vehicle list and the customer status page up to date
+type BoardHandler struct {
+	trackingService *services.TrackingService
+	broker          *services.Broker
+}
+
+func NewBoardHandler(trackingService *services.TrackingService, broker *services.Broker) *BoardHandler {
+	return &BoardHandler{trackingService: trackingService, broker: broker}
+}
+
+func (h *BoardHandler) Board(c *gin.Context) {
+	board, err := h.trackingService.GetBoard()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "board.html", gin.H{"Error": err.Error(), "BusinessName": services.BusinessName})
+		return
+	}
+	c.HTML(http.StatusOK, "board.html", gin.H{"Board": board, "BusinessName": services.BusinessName})
+}
+
+// BoardData is fetched by the board whenever the queue changes
+func (h *BoardHandler) BoardData(c *gin.Context) {
+	board, err := h.trackingService.GetBoard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, board)
+}
+
+// BoardStream tells public pages that the queue changed, without saying which vehicle
+func (h *BoardHandler) BoardStream(c *gin.Context) {
+	h.stream(c, true)
+}
+
+// VehicleStream sends staff every vehicle change as it happens
+func (h *BoardHandler) VehicleStream(c *gin.Context) {
+	h.stream(c, false)
+}
+
+// stream sends vehicle changes as Server-Sent Events "change" until the client goes away
+func (h *BoardHandler) stream(c *gin.Context, public bool) {
+	changes, unsubscribe := h.broker.Subscribe()
+	defer unsubscribe()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case change := <-changes:
+			if public {
+				change = services.VehicleChange{Type: change.Type}
+			}
+			c.SSEvent("change", change)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		}
+	})
+}
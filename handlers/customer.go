@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type CustomerHandler struct {
+	service *services.CustomerService
+}
+
+func NewCustomerHandler(service *services.CustomerService) *CustomerHandler {
+	return &CustomerHandler{service: service}
+}
+
+func (h *CustomerHandler) GetCustomers(c *gin.Context) {
+	query := c.Query("q")
+	customers, err := h.service.GetCustomers(query)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "customers.html", gin.H{"Error": err.Error(), "Query": query})
+		return
+	}
+	c.HTML(http.StatusOK, "customers.html", gin.H{
+		"Customers": customers,
+		"Query":     query,
+	})
+}
+
+// SearchCustomers answers the customer autocomplete on the check-in form
+func (h *CustomerHandler) SearchCustomers(c *gin.Context) {
+	customers, err := h.service.SearchCustomers(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, customers)
+}
+
+func (h *CustomerHandler) GetCustomerByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/customers")
+		return
+	}
+	customer, err := h.service.GetCustomerByID(uint(id))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/customers")
+		return
+	}
+	history, err := h.service.GetWashHistory(customer.ID)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "customer.html", gin.H{"Error": err.Error(), "Customer": customer})
+		return
+	}
+	c.HTML(http.StatusOK, "customer.html", gin.H{
+		"Customer": customer,
+		"History":  history,
+	})
+}
+
+func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/customers")
+		return
+	}
+	action := "/customers/" + c.Param("id") + "/edit"
+
+	// Show edit form for GET requests
+	if c.Request.Method == http.MethodGet {
+		customer, err := h.service.GetCustomerByID(uint(id))
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/customers")
+			return
+		}
+		c.HTML(http.StatusOK, "customerform.html", gin.H{
+			"Action": action,
+			"Name":   customer.Name,
+			"Phone":  customer.Phone,
+			"Email":  customer.Email,
+			"Notes":  customer.Notes,
+		})
+		return
+	}
+
+	var input repositories.CustomerRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "customerform.html", customerFormData(action, input, err))
+		return
+	}
+
+	if err := h.service.UpdateCustomer(uint(id), input); err != nil {
+		status := http.StatusInternalServerError
+		if err == repositories.ErrInvalidPhone || err == repositories.ErrDuplicatePhone {
+			status = http.StatusBadRequest
+		}
+		c.HTML(status, "customerform.html", customerFormData(action, input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/customers/"+c.Param("id"))
+}
+
+// customerFormData refills the customer form after a failed submit
+func customerFormData(action string, input repositories.CustomerRequest, err error) gin.H {
+	return gin.H{
+		"Error":  err.Error(),
+		"Action": action,
+		"Name":   input.Name,
+		"Phone":  input.Phone,
+		"Email":  input.Email,
+		"Notes":  input.Notes,
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/middleware"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type InvoiceHandler struct {
+	service        *services.InvoiceService
+	vehicleService *services.VehicleService
+}
+
+func NewInvoiceHandler(service *services.InvoiceService, vehicleService *services.VehicleService) *InvoiceHandler {
+	return &InvoiceHandler{service: service, vehicleService: vehicleService}
+}
+
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	h.renderInvoice(c, http.StatusOK, "")
+}
+
+// OpenInvoice bills a visit from before invoicing for its package
+func (h *InvoiceHandler) OpenInvoice(c *gin.Context) {
+	if err := h.service.OpenInvoice(c.Param("id")); err != nil {
+		h.renderInvoice(c, errorStatus(err), err.Error())
+		return
+	}
+	h.redirectToInvoice(c)
+}
+
+func (h *InvoiceHandler) AddItem(c *gin.Context) {
+	var input repositories.InvoiceItemRequest
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderInvoice(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.service.AddItem(c.Param("id"), input); err != nil {
+		h.renderInvoice(c, errorStatus(err), err.Error())
+		return
+	}
+	h.redirectToInvoice(c)
+}
+
+func (h *InvoiceHandler) RemoveItem(c *gin.Context) {
+	itemID, err := strconv.ParseUint(c.Param("item"), 10, 64)
+	if err != nil {
+		h.redirectToInvoice(c)
+		return
+	}
+	if err := h.service.RemoveItem(c.Param("id"), uint(itemID)); err != nil {
+		h.renderInvoice(c, errorStatus(err), err.Error())
+		return
+	}
+	h.redirectToInvoice(c)
+}
+
+func (h *InvoiceHandler) SetDiscount(c *gin.Context) {
+	if err := h.service.SetDiscount(c.Param("id"), c.PostForm("discount"), c.PostForm("note")); err != nil {
+		h.renderInvoice(c, errorStatus(err), err.Error())
+		return
+	}
+	h.redirectToInvoice(c)
+}
+
+func (h *InvoiceHandler) Pay(c *gin.Context) {
+	var input repositories.PaymentRequest
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderInvoice(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.service.Pay(c.Param("id"), input, currentUserID(c)); err != nil {
+		h.renderInvoice(c, errorStatus(err), err.Error())
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s/receipt", c.Param("id")))
+}
+
+// renderInvoice shows the invoice page of a vehicle, optionally with an error message
+func (h *InvoiceHandler) renderInvoice(c *gin.Context, status int, errMsg string) {
+	vehicle, err := h.vehicleService.GetVehicleByID(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/vehicles")
+		return
+	}
+	invoice, err := h.service.GetInvoice(vehicle.ID)
+	if errors.Is(err, services.ErrNoInvoice) {
+		c.HTML(status, "invoice.html", gin.H{"Error": errMsg, "Vehicle": vehicle})
+		return
+	}
+	if err != nil {
+		c.HTML(errorStatus(err), "invoice.html", gin.H{"Error": err.Error(), "Vehicle": vehicle})
+		return
+	}
+	c.HTML(status, "invoice.html", gin.H{
+		"Error":          errMsg,
+		"Vehicle":        vehicle,
+		"Invoice":        invoice,
+		"PaymentMethods": repositories.PaymentMethods,
+		"CanManage":      middleware.HasRole(c, managerRoles...),
+	})
+}
+
+func (h *InvoiceHandler) redirectToInvoice(c *gin.Context) {
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s/invoice", c.Param("id")))
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type NotificationHandler struct {
+	service *services.NotificationService
+}
+
+func NewNotificationHandler(service *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{service: service}
+}
+
+func (h *NotificationHandler) GetTemplates(c *gin.Context) {
+	h.renderTemplates(c, http.StatusOK, "")
+}
+
+func (h *NotificationHandler) UpdateTemplate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/notifications")
+		return
+	}
+	var input repositories.MessageTemplateRequest
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderTemplates(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.service.UpdateTemplate(uint(id), input); err != nil {
+		h.renderTemplates(c, errorStatus(err), err.Error())
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/notifications")
+}
+
+func (h *NotificationHandler) renderTemplates(c *gin.Context, status int, errMsg string) {
+	templates, err := h.service.GetTemplates()
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	c.HTML(status, "notifications.html", gin.H{
+		"Error":        errMsg,
+		"Templates":    templates,
+		"Placeholders": services.TemplatePlaceholders,
+	})
+}
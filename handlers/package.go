@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type PackageHandler struct {
+	service *services.PackageService
+}
+
+func NewPackageHandler(service *services.PackageService) *PackageHandler {
+	return &PackageHandler{service: service}
+}
+
+func (h *PackageHandler) GetPackages(c *gin.Context) {
+	packages, err := h.service.GetPackages()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "packages.html", gin.H{"Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "packages.html", gin.H{
+		"Packages": packages,
+	})
+}
+
+func (h *PackageHandler) CreatePackage(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		c.HTML(http.StatusOK, "packageform.html", gin.H{
+			"Action":         "/packages/new",
+			"Active":         true,
+			"VehicleClasses": repositories.VehicleClasses,
+		})
+		return
+	}
+
+	var input repositories.PackageRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "packageform.html", packageFormData("/packages/new", input, err))
+		return
+	}
+
+	if _, err := h.service.CreatePackage(&input); err != nil {
+		c.HTML(http.StatusInternalServerError, "packageform.html", packageFormData("/packages/new", input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/packages")
+}
+
+func (h *PackageHandler) UpdatePackage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/packages")
+		return
+	}
+	action := "/packages/" + c.Param("id") + "/edit"
+
+	// Show edit form for GET requests
+	if c.Request.Method == http.MethodGet {
+		pkg, err := h.service.GetPackageByID(uint(id))
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/packages")
+			return
+		}
+		c.HTML(http.StatusOK, "packageform.html", gin.H{
+			"Action":         action,
+			"Name":           pkg.Name,
+			"Duration":       pkg.Duration,
+			"Price":          pkg.Price,
+			"VehicleClass":   pkg.VehicleClass,
+			"Active":         pkg.Active,
+			"VehicleClasses": repositories.VehicleClasses,
+		})
+		return
+	}
+
+	var input repositories.PackageRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "packageform.html", packageFormData(action, input, err))
+		return
+	}
+
+	if err := h.service.UpdatePackage(uint(id), input); err != nil {
+		c.HTML(http.StatusInternalServerError, "packageform.html", packageFormData(action, input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/packages")
+}
+
+func (h *PackageHandler) DeletePackage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/packages")
+		return
+	}
+
+	if err := h.service.DeletePackage(uint(id)); err != nil {
+		c.HTML(http.StatusInternalServerError, "packages.html", gin.H{"Error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/packages")
+}
+
+// packageFormData refills the package form after a failed submit
+func packageFormData(action string, input repositories.PackageRequest, err error) gin.H {
+	return gin.H{
+		"Error":          err.Error(),
+		"Action":         action,
+		"Name":           input.Name,
+		"Duration":       input.Duration,
+		"Price":          input.Price,
+		"VehicleClass":   input.VehicleClass,
+		"Active":         input.Active,
+		"VehicleClasses": repositories.VehicleClasses,
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/services"
+)
+
+// PrintHandler serves the queue ticket and receipt, as a print view for the
+// browser or with ?format=escpos as raw bytes for a thermal printer
+type PrintHandler struct {
+	vehicleService *services.VehicleService
+	invoiceService *services.InvoiceService
+}
+
+func NewPrintHandler(vehicleService *services.VehicleService, invoiceService *services.InvoiceService) *PrintHandler {
+	return &PrintHandler{vehicleService: vehicleService, invoiceService: invoiceService}
+}
+
+func (h *PrintHandler) Ticket(c *gin.Context) {
+	vehicle, err := h.vehicleService.GetVehicleByID(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/vehicles")
+		return
+	}
+	statusURL := publicURL(c, "/track/"+vehicle.TrackingToken)
+
+	if c.Query("format") == "escpos" {
+		sendESCPOS(c, "ticket-"+vehicle.ID, services.TicketESCPOS(vehicle, statusURL))
+		return
+	}
+
+	png, err := services.QRCodePNG(statusURL, 256)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "ticket.html", gin.H{"Error": err.Error(), "Vehicle": vehicle})
+		return
+	}
+	c.HTML(http.StatusOK, "ticket.html", gin.H{
+		"Vehicle":      vehicle,
+		"BusinessName": services.BusinessName,
+		"StatusURL":    statusURL,
+		"QRCode":       template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+	})
+}
+
+func (h *PrintHandler) Receipt(c *gin.Context) {
+	vehicle, err := h.vehicleService.GetVehicleByID(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/vehicles")
+		return
+	}
+	invoice, err := h.invoiceService.GetInvoice(vehicle.ID)
+	if err != nil {
+		c.HTML(errorStatus(err), "receipt.html", gin.H{"Error": err.Error(), "Vehicle": vehicle})
+		return
+	}
+
+	if c.Query("format") == "escpos" {
+		sendESCPOS(c, "receipt-"+invoice.Number, services.ReceiptESCPOS(vehicle, invoice))
+		return
+	}
+
+	c.HTML(http.StatusOK, "receipt.html", gin.H{
+		"Vehicle":      vehicle,
+		"Invoice":      invoice,
+		"BusinessName": services.BusinessName,
+	})
+}
+
+// sendESCPOS downloads printer commands, to be sent as-is to the printer
+func sendESCPOS(c *gin.Context, name string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".bin"))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// publicURL makes path absolute for links leaving the browser, like QR codes.
+// PUBLIC_URL is used when set, as the server may sit behind a proxy.
+func publicURL(c *gin.Context, path string) string {
+	if services.PublicURL != "" {
+		return strings.TrimRight(services.PublicURL, "/") + path
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + path
+}
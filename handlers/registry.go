@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type RegistryHandler struct {
+	service *services.RegistryService
+}
+
+func NewRegistryHandler(service *services.RegistryService) *RegistryHandler {
+	return &RegistryHandler{service: service}
+}
+
+func (h *RegistryHandler) GetRegisteredVehicles(c *gin.Context) {
+	query := c.Query("q")
+	vehicles, err := h.service.GetRegisteredVehicles(query)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "registry.html", gin.H{"Error": err.Error(), "Query": query})
+		return
+	}
+	c.HTML(http.StatusOK, "registry.html", gin.H{
+		"Vehicles": vehicles,
+		"Query":    query,
+	})
+}
+
+// LookupPlate answers the check-in form when a plate is entered, so a
+// returning vehicle brings its customer and last package along
+func (h *RegistryHandler) LookupPlate(c *gin.Context) {
+	lookup, err := h.service.LookupPlate(c.Query("plate"))
+	if err != nil {
+		if isNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"Error": "Plate is not registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"vehicle": lookup.Vehicle,
+		"visits":  lookup.Visits,
+	})
+}
+
+func (h *RegistryHandler) GetRegisteredVehicleByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/registry")
+		return
+	}
+	vehicle, err := h.service.GetRegisteredVehicleByID(uint(id))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/registry")
+		return
+	}
+	visits, err := h.service.GetVisits(vehicle.ID)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "registeredvehicle.html", gin.H{"Error": err.Error(), "Vehicle": vehicle})
+		return
+	}
+	c.HTML(http.StatusOK, "registeredvehicle.html", gin.H{
+		"Vehicle": vehicle,
+		"Visits":  visits,
+	})
+}
+
+func (h *RegistryHandler) UpdateRegisteredVehicle(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/registry")
+		return
+	}
+	action := "/registry/" + c.Param("id") + "/edit"
+
+	// Show edit form for GET requests
+	if c.Request.Method == http.MethodGet {
+		vehicle, err := h.service.GetRegisteredVehicleByID(uint(id))
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/registry")
+			return
+		}
+		c.HTML(http.StatusOK, "registeredvehicleform.html", gin.H{
+			"Action":         action,
+			"Plate":          vehicle.Plate,
+			"Make":           vehicle.Make,
+			"Model":          vehicle.Model,
+			"Color":          vehicle.Color,
+			"VehicleClass":   vehicle.VehicleClass,
+			"VehicleClasses": repositories.VehicleClasses,
+		})
+		return
+	}
+
+	var input repositories.RegisteredVehicleRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "registeredvehicleform.html", registeredVehicleFormData(action, input, err))
+		return
+	}
+
+	if err := h.service.UpdateRegisteredVehicle(uint(id), input); err != nil {
+		status := http.StatusInternalServerError
+		if err == repositories.ErrInvalidPlate || err == repositories.ErrDuplicatePlate {
+			status = http.StatusBadRequest
+		}
+		c.HTML(status, "registeredvehicleform.html", registeredVehicleFormData(action, input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/registry/"+c.Param("id"))
+}
+
+// registeredVehicleFormData refills the registered vehicle form after a failed submit
+func registeredVehicleFormData(action string, input repositories.RegisteredVehicleRequest, err error) gin.H {
+	return gin.H{
+		"Error":          err.Error(),
+		"Action":         action,
+		"Plate":          input.Plate,
+		"Make":           input.Make,
+		"Model":          input.Model,
+		"Color":          input.Color,
+		"VehicleClass":   input.VehicleClass,
+		"VehicleClasses": repositories.VehicleClasses,
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/middleware"
+	"nevacarwash.com/main/services"
+)
+
+type SessionHandler struct {
+	service *services.SessionService
+}
+
+func NewSessionHandler(service *services.SessionService) *SessionHandler {
+	return &SessionHandler{service: service}
+}
+
+// GetSessions lists the devices the user is signed in on
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	h.renderSessions(c, http.StatusOK, "")
+}
+
+// Revoke signs the user out on one of their devices
+func (h *SessionHandler) Revoke(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/sessions")
+		return
+	}
+
+	if err := h.service.Revoke(currentUserID(c), uint(id)); err != nil {
+		h.renderSessions(c, errorStatus(err), err.Error())
+		return
+	}
+
+	if uint(id) == middleware.CurrentSessionID(c) {
+		middleware.ClearSessionCookies(c)
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/sessions")
+}
+
+// RevokeAll signs the user out everywhere, including this browser
+func (h *SessionHandler) RevokeAll(c *gin.Context) {
+	if err := h.service.RevokeAll(currentUserID(c)); err != nil {
+		h.renderSessions(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	middleware.ClearSessionCookies(c)
+	c.Redirect(http.StatusSeeOther, "/login")
+}
+
+func (h *SessionHandler) renderSessions(c *gin.Context, status int, errMsg string) {
+	sessions, err := h.service.GetSessions(currentUserID(c))
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+		status = http.StatusInternalServerError
+	}
+	c.HTML(status, "sessions.html", gin.H{
+		"Error":     errMsg,
+		"Sessions":  sessions,
+		"CurrentID": middleware.CurrentSessionID(c),
+	})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/services"
+)
+
+// TrackHandler serves the public status page customers reach from the QR
+// code on their ticket. It needs no login, the token in the link is the key.
+type TrackHandler struct {
+	service *services.TrackingService
+}
+
+func NewTrackHandler(service *services.TrackingService) *TrackHandler {
+	return &TrackHandler{service: service}
+}
+
+func (h *TrackHandler) Track(c *gin.Context) {
+	status, err := h.service.GetStatus(c.Param("token"))
+	if err != nil {
+		if isNotFound(err) {
+			c.HTML(http.StatusNotFound, "track.html", gin.H{"Error": "This tracking link is not valid."})
+			return
+		}
+		c.HTML(http.StatusInternalServerError, "track.html", gin.H{"Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "track.html", gin.H{
+		"Status":       status,
+		"Token":        c.Param("token"),
+		"BusinessName": services.BusinessName,
+	})
+}
+
+// Status is polled by the status page to keep it up to date
+func (h *TrackHandler) Status(c *gin.Context) {
+	status, err := h.service.GetStatus(c.Param("token"))
+	if err != nil {
+		if isNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"Error": "Tracking link not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/middleware"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type UserHandler struct {
+	service   *services.UserService
+	logins    *services.LoginService
+	accounts  *services.AccountService
+	twoFactor *services.TwoFactorService
+}
+
+func NewUserHandler(service *services.UserService, logins *services.LoginService, accounts *services.AccountService, twoFactor *services.TwoFactorService) *UserHandler {
+	return &UserHandler{service: service, logins: logins, accounts: accounts, twoFactor: twoFactor}
+}
+
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	h.renderUsers(c, http.StatusOK, "")
+}
+
+// AssignRole changes the role of a user from the users page
+func (h *UserHandler) AssignRole(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/users")
+		return
+	}
+
+	err = h.service.AssignRole(middleware.CurrentRole(c), uint(id), c.PostForm("role"))
+	if err != nil {
+		h.renderUsers(c, roleErrorStatus(err), err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/users")
+}
+
+// Unlock lets a locked out user try logging in again right away
+func (h *UserHandler) Unlock(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/users")
+		return
+	}
+
+	if err := h.logins.Unlock(uint(id)); err != nil {
+		h.renderUsers(c, roleErrorStatus(err), err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/users")
+}
+
+// CreateInvite emails a registration link for a new staff member and shows
+// it, so it can be passed on by hand too
+func (h *UserHandler) CreateInvite(c *gin.Context) {
+	var input repositories.InviteRequest
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderUsers(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, err := h.accounts.CreateInvite(publicURL(c, ""), currentUserID(c), middleware.CurrentRole(c), input)
+	if err != nil {
+		h.renderUsers(c, accountErrorStatus(err), err.Error())
+		return
+	}
+
+	h.renderUsersPage(c, http.StatusOK, gin.H{
+		"Success": fmt.Sprintf("Invite sent to %s. The link works for %d days:", input.Email, int(services.InviteTTL.Hours()/24)),
+		"Link":    link,
+	})
+}
+
+// RevokeInvite stops an unused invite link from working
+func (h *UserHandler) RevokeInvite(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/users")
+		return
+	}
+
+	if err := h.accounts.RevokeInvite(uint(id)); err != nil {
+		h.renderUsers(c, accountErrorStatus(err), err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/users")
+}
+
+// ResetPassword emails the user a link to pick a new password and shows it,
+// for users without an email address
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/users")
+		return
+	}
+
+	link, user, err := h.accounts.CreatePasswordReset(publicURL(c, ""), currentUserID(c), middleware.CurrentRole(c), uint(id))
+	if err != nil {
+		h.renderUsers(c, accountErrorStatus(err), err.Error())
+		return
+	}
+
+	minutes := int(services.PasswordResetTTL.Minutes())
+	notice := fmt.Sprintf("Give %s this reset link, it works for %d minutes:", user.Username, minutes)
+	if user.Email != "" {
+		notice = fmt.Sprintf("Reset link sent to %s. It works for %d minutes:", user.Email, minutes)
+	}
+	h.renderUsersPage(c, http.StatusOK, gin.H{"Success": notice, "Link": link})
+}
+
+// ResetTwoFactor removes the authenticator of a user who lost their phone and
+// their recovery codes, so they can set up a new one
+func (h *UserHandler) ResetTwoFactor(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/users")
+		return
+	}
+
+	user, err := h.twoFactor.Reset(middleware.CurrentRole(c), uint(id))
+	if err != nil {
+		h.renderUsers(c, accountErrorStatus(err), err.Error())
+		return
+	}
+
+	notice := fmt.Sprintf("Two-factor authentication of %s was reset, they can set it up again from their account page", user.Username)
+	if services.RequiresTwoFactor(user.Role) {
+		notice = fmt.Sprintf("Two-factor authentication of %s was reset, they set up a new authenticator on their next login", user.Username)
+	}
+	h.renderUsersPage(c, http.StatusOK, gin.H{"Success": notice})
+}
+
+// GetLoginAttempts shows the login audit log
+func (h *UserHandler) GetLoginAttempts(c *gin.Context) {
+	attempts, err := h.logins.GetAttempts()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "logins.html", gin.H{"Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "logins.html", gin.H{"Attempts": attempts})
+}
+
+func (h *UserHandler) renderUsers(c *gin.Context, status int, errMsg string) {
+	h.renderUsersPage(c, status, gin.H{"Error": errMsg})
+}
+
+func (h *UserHandler) renderUsersPage(c *gin.Context, status int, data gin.H) {
+	users, err := h.service.GetUsers()
+	if err == nil {
+		data["Invites"], err = h.accounts.GetInvites()
+	}
+	if msg, _ := data["Error"].(string); err != nil && msg == "" {
+		data["Error"] = err.Error()
+		status = http.StatusInternalServerError
+	}
+	data["Users"] = users
+	data["Roles"] = repositories.Roles
+	data["CurrentRole"] = middleware.CurrentRole(c)
+	data["CurrentID"] = currentUserID(c)
+	data["Now"] = time.Now()
+	c.HTML(status, "users.html", data)
+}
+
+// roleErrorStatus maps role assignment errors to the HTTP status they should be reported with
+func roleErrorStatus(err error) int {
+	switch err {
+	case services.ErrInvalidRole:
+		return http.StatusBadRequest
+	case services.ErrRoleForbidden:
+		return http.StatusForbidden
+	case services.ErrLastOwner:
+		return http.StatusConflict
+	}
+	if isNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
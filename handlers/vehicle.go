@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"nevacarwash.com/main/middleware"
@@ -12,23 +14,38 @@ import (
 )
 
 type VehicleHandler struct {
-	service *services.VehicleService
+	service        *services.VehicleService
+	packageService *services.PackageService
+	bayService     *services.BayService
+	invoiceService *services.InvoiceService
+	notifications  *services.NotificationService
 }
 
-func NewVehicleHandler(service *services.VehicleService) *VehicleHandler {
-	return &VehicleHandler{service: service}
+func NewVehicleHandler(service *services.VehicleService, packageService *services.PackageService, bayService *services.BayService, invoiceService *services.InvoiceService, notifications *services.NotificationService) *VehicleHandler {
+	return &VehicleHandler{service: service, packageService: packageService, bayService: bayService, invoiceService: invoiceService, notifications: notifications}
 }
 
 func (h *VehicleHandler) CreateVehicle(c *gin.Context) {
+	packages, err := h.packageService.GetActivePackages()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "create.html", gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
 	if c.Request.Method == http.MethodGet {
-		c.HTML(http.StatusOK, "create.html", nil)
+		c.HTML(http.StatusOK, "create.html", gin.H{
+			"Packages": packages,
+		})
 		return
 	}
 
 	var vehicle repositories.CreateVehicleRequest
 	if err := c.ShouldBind(&vehicle); err != nil {
 		c.HTML(http.StatusBadRequest, "create.html", gin.H{
-			"Error": err.Error(),
+			"Error":    err.Error(),
+			"Packages": packages,
 		})
 		return
 	}
@@ -50,13 +67,14 @@ func (h *VehicleHandler) CreateVehicle(c *gin.Context) {
 	vehicle.UID = fmt.Sprintf("%d", uint(idFloat))
 	vehicleID, err := h.service.CreateVehicle(&vehicle)
 	if err != nil {
-		c.HTML(http.StatusInternalServerError, "create.html", gin.H{
-			"Error": err.Error(),
+		c.HTML(errorStatus(err), "create.html", gin.H{
+			"Error":    err.Error(),
+			"Packages": packages,
 		})
 		return
 	}
 
-	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s", vehicleID))
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s/ticket", vehicleID))
 }
 
 func (h *VehicleHandler) GetVehiclesByUsername(c *gin.Context) {
@@ -94,7 +112,7 @@ func (h *VehicleHandler) GetVehiclesByUsername(c *gin.Context) {
 }
 
 func (h *VehicleHandler) GetVehiclesByProcess(c *gin.Context) {
-	process := []string{"Waiting", "Washing", "Finish"}
+	process := []string{services.ProcessWaiting, services.ProcessWashing, services.ProcessFinish}
 
 	// Call the service to get the vehicles grouped by status
 	groupedVehicles, err := h.service.GetVehiclesByProcess(process)
@@ -104,12 +122,71 @@ func (h *VehicleHandler) GetVehiclesByProcess(c *gin.Context) {
 		return
 	}
 
+	bayBoard, err := h.service.GetBayBoard()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "list.html", gin.H{"error": err.Error()})
+		return
+	}
+
 	// Pass the grouped vehicles to the template
 	c.HTML(http.StatusOK, "list.html", gin.H{
 		"groupedVehicles": groupedVehicles,
+		"bayBoard":        bayBoard,
+		"today":           repositories.Today(),
 	})
 }
 
+// CloseDay lets an admin carry unfinished vehicles forward or abandon them at the end of the day
+func (h *VehicleHandler) CloseDay(c *gin.Context) {
+	vehicles, err := h.service.GetUnfinishedVehicles()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "closeday.html", gin.H{"Error": err.Error()})
+		return
+	}
+	carryDate := time.Now().In(repositories.BusinessLocation).AddDate(0, 0, 1).Format("2006-01-02")
+
+	if c.Request.Method == http.MethodGet {
+		c.HTML(http.StatusOK, "closeday.html", gin.H{
+			"Vehicles":  vehicles,
+			"CarryDate": carryDate,
+			"Today":     repositories.Today(),
+		})
+		return
+	}
+
+	if date := c.PostForm("carry_date"); date != "" {
+		carryDate = date
+	}
+	actions := c.PostFormMap("action")
+	reasons := c.PostFormMap("reason")
+
+	var decisions []services.CloseDecision
+	for _, vehicle := range vehicles {
+		action, ok := actions[vehicle.ID]
+		if !ok {
+			continue
+		}
+		decisions = append(decisions, services.CloseDecision{
+			VehicleID: vehicle.ID,
+			Carry:     action != "abandon",
+			Reason:    reasons[vehicle.ID],
+		})
+	}
+
+	if err := h.service.CloseDay(decisions, carryDate, currentUserID(c)); err != nil {
+		vehicles, _ = h.service.GetUnfinishedVehicles()
+		c.HTML(errorStatus(err), "closeday.html", gin.H{
+			"Error":     err.Error(),
+			"Vehicles":  vehicles,
+			"CarryDate": carryDate,
+			"Today":     repositories.Today(),
+		})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/vehicles")
+}
+
 func (h *VehicleHandler) GetVehicleByID(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
@@ -121,18 +198,65 @@ func (h *VehicleHandler) GetVehicleByID(c *gin.Context) {
 		c.Redirect(http.StatusSeeOther, "/vehicles")
 		return
 	}
-	var currentUserID uint
-	var username string
-	claims := middleware.JwtClaims(c)
-	if claims != nil {
-		if idFloat, ok := claims["id"].(float64); ok {
-			currentUserID = uint(idFloat)
-		}
-		if usernameClaim, ok := claims["username"].(string); ok {
-			username = usernameClaim
-		}
+	h.renderVehicle(c, http.StatusOK, vehicle, "")
+}
+
+func (h *VehicleHandler) GetVehicleEvents(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.service.GetVehicleByID(id); err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	events, err := h.service.GetVehicleEvents(id)
+	if err != nil {
+		apiServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, eventTimeline(events))
+}
+
+// eventTimeline shapes vehicle events for JSON responses
+func eventTimeline(events []repositories.VehicleEvent) []gin.H {
+	timeline := make([]gin.H, 0, len(events))
+	for _, event := range events {
+		timeline = append(timeline, gin.H{
+			"id":           event.ID,
+			"type":         event.Type,
+			"from_process": event.FromProcess,
+			"to_process":   event.ToProcess,
+			"note":         event.Note,
+			"actor_id":     event.ActorID,
+			"actor":        event.Actor.Username,
+			"created_at":   event.CreatedAt,
+		})
+	}
+	return timeline
+}
+
+// renderVehicle shows the vehicle detail page, optionally with an error message
+func (h *VehicleHandler) renderVehicle(c *gin.Context, status int, vehicle *repositories.Vehicle, errMsg string) {
+	events, err := h.service.GetVehicleEvents(vehicle.ID)
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	freeBays, err := h.bayService.GetFreeBays()
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	var bayName string
+	if vehicle.Bay != nil {
+		bayName = vehicle.Bay.Name
 	}
-	c.HTML(http.StatusOK, "viewvehicle.html", gin.H{
+	invoice, err := h.invoiceService.GetInvoice(vehicle.ID)
+	if err != nil && !errors.Is(err, services.ErrNoInvoice) && errMsg == "" {
+		errMsg = err.Error()
+	}
+	notifications, err := h.notifications.GetNotifications(vehicle.ID)
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	c.HTML(status, "viewvehicle.html", gin.H{
+		"Error":         errMsg,
 		"Name":          vehicle.Name,
 		"Package":       vehicle.Package,
 		"Username":      vehicle.User.Username,
@@ -142,17 +266,55 @@ func (h *VehicleHandler) GetVehicleByID(c *gin.Context) {
 		"Date":          vehicle.Date,
 		"EnterTime":     vehicle.EnterTime,
 		"ID":            vehicle.ID,
-		"IsOwner":       currentUserID == vehicle.UserID,
-		"IsAdmin":       username,
+		"CanManage":     middleware.HasRole(c, managerRoles...),
+		"CanDelete":     canDeleteVehicle(c, vehicle),
+		"CanTransition": middleware.HasRole(c, washRoles...),
+		"CanPickup":     middleware.HasRole(c, frontDeskRoles...),
+		"Invoice":       invoice,
 		"EstimatedTime": vehicle.EstimatedTime,
 		"FinishTime":    vehicle.FinishTime,
-		"CurrentUser":   username,
+		"Transitions":   services.AllowedTransitions(vehicle.Process),
+		"Overdue":       vehicle.Date.Before(repositories.Today()) && (vehicle.Process == services.ProcessWaiting || vehicle.Process == services.ProcessWashing),
+		"Events":        events,
+		"Bay":           bayName,
+		"Customer":      vehicle.Customer,
+		"Registered":    vehicle.RegisteredVehicle,
+		"FreeBays":      freeBays,
+		"TrackingToken": vehicle.TrackingToken,
+		"Notifications": notifications,
 	})
 }
 
+// RetryNotification sends a message the customer did not get again
+func (h *VehicleHandler) RetryNotification(c *gin.Context) {
+	vehicle, err := h.service.GetVehicleByID(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/vehicles")
+		return
+	}
+	notificationID, err := strconv.ParseUint(c.Param("notification"), 10, 64)
+	if err != nil {
+		h.renderVehicle(c, http.StatusBadRequest, vehicle, "Invalid notification")
+		return
+	}
+	if err := h.notifications.Retry(vehicle.ID, uint(notificationID)); err != nil {
+		h.renderVehicle(c, errorStatus(err), vehicle, err.Error())
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s", vehicle.ID))
+}
+
 func (h *VehicleHandler) UpdateVehicle(c *gin.Context) {
 	id := c.Param("id")
 
+	packages, err := h.packageService.GetActivePackages()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "edit.html", gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
 	// Show edit form for GET requests
 	if c.Request.Method == http.MethodGet {
 		vehicle, err := h.service.GetVehicleByID(id)
@@ -163,30 +325,15 @@ func (h *VehicleHandler) UpdateVehicle(c *gin.Context) {
 			return
 		}
 
-		// Check if user owns this vehicle
-		claims := middleware.JwtClaims(c)
-		if claims == nil {
-			c.Redirect(http.StatusSeeOther, "/login")
-			return
-		}
-		var username string
-		if usernameClaim, ok := claims["username"].(string); ok {
-			username = usernameClaim
-			if !strings.Contains(username, "@admin") {
-				c.HTML(http.StatusForbidden, "edit.html", gin.H{
-					"Error": "Not authorized to edit this vehicle",
-				})
-				return
-			}
-		}
-
 		c.HTML(http.StatusOK, "edit.html", gin.H{
-			"ID":      vehicle.ID,
-			"Name":    vehicle.Name,
-			"Package": vehicle.Package,
-			"Contact": vehicle.Contact,
-			"Process": vehicle.Process,
-			"Plate":   vehicle.Plate,
+			"ID":        vehicle.ID,
+			"Name":      vehicle.Name,
+			"Package":   vehicle.Package,
+			"Contact":   vehicle.Contact,
+			"Process":   vehicle.Process,
+			"Plate":     vehicle.Plate,
+			"Packages":  packages,
+			"Processes": h.processOptions(id),
 		})
 		return
 	}
@@ -195,25 +342,30 @@ func (h *VehicleHandler) UpdateVehicle(c *gin.Context) {
 	var updatedVehicle repositories.CreateVehicleRequest
 	if err := c.ShouldBind(&updatedVehicle); err != nil {
 		c.HTML(http.StatusBadRequest, "edit.html", gin.H{
-			"Error":   err.Error(),
-			"Name":    updatedVehicle.Name,
-			"Package": updatedVehicle.Package,
-			"Contact": updatedVehicle.Contact,
-			"Process": updatedVehicle.Process,
-			"Plate":   updatedVehicle.Plate,
+			"Error":     err.Error(),
+			"ID":        id,
+			"Name":      updatedVehicle.Name,
+			"Package":   updatedVehicle.Package,
+			"Contact":   updatedVehicle.Contact,
+			"Process":   updatedVehicle.Process,
+			"Plate":     updatedVehicle.Plate,
+			"Packages":  packages,
+			"Processes": h.processOptions(id),
 		})
 		return
 	}
 
-	if err := h.service.UpdateVehicle(id, updatedVehicle); err != nil {
-		c.HTML(http.StatusInternalServerError, "edit.html", gin.H{
-			"Error":   err.Error(),
-			"ID":      id,
-			"Name":    updatedVehicle.Name,
-			"Package": updatedVehicle.Package,
-			"Contact": updatedVehicle.Contact,
-			"Process": updatedVehicle.Process,
-			"Plate":   updatedVehicle.Plate,
+	if err := h.service.UpdateVehicle(id, updatedVehicle, currentUserID(c)); err != nil {
+		c.HTML(errorStatus(err), "edit.html", gin.H{
+			"Error":     err.Error(),
+			"ID":        id,
+			"Name":      updatedVehicle.Name,
+			"Package":   updatedVehicle.Package,
+			"Contact":   updatedVehicle.Contact,
+			"Process":   updatedVehicle.Process,
+			"Plate":     updatedVehicle.Plate,
+			"Packages":  packages,
+			"Processes": h.processOptions(id),
 		})
 		return
 	}
@@ -221,118 +373,132 @@ func (h *VehicleHandler) UpdateVehicle(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s", id))
 }
 
+// processOptions lists the current process of a vehicle followed by the ones it can move to
+func (h *VehicleHandler) processOptions(id string) []string {
+	vehicle, err := h.service.GetVehicleByID(id)
+	if err != nil {
+		return nil
+	}
+	return append([]string{vehicle.Process}, services.AllowedTransitions(vehicle.Process)...)
+}
+
 func (h *VehicleHandler) DeleteVehicle(c *gin.Context) {
 	id := c.Param("id")
 
-	// Show delete confirmation for GET requests
-	if c.Request.Method == http.MethodGet {
-		vehicle, err := h.service.GetVehicleByID(id)
-		if err != nil {
-			c.HTML(http.StatusInternalServerError, "mylist.html", gin.H{
-				"Error": err.Error(),
-			})
-			return
-		}
-
-		// Check if user owns this vehicle
-		claims := middleware.JwtClaims(c)
-		if claims == nil {
-			c.Redirect(http.StatusSeeOther, "/login")
-			return
-		}
-
-		if idFloat, ok := claims["id"].(float64); ok {
-			currentUserID := uint(idFloat)
-			if currentUserID != vehicle.UserID {
-				c.HTML(http.StatusForbidden, "mylist.html", gin.H{
-					"Error": "Not authorized to delete this vehicle",
-				})
-				return
-			}
-		}
-
-		c.Redirect(http.StatusOK, "/vehicles")
+	vehicle, err := h.service.GetVehicleByID(id)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/vehicles")
+		return
+	}
+	if !canDeleteVehicle(c, vehicle) {
+		h.renderVehicle(c, http.StatusForbidden, vehicle, "Not authorized to delete this vehicle")
 		return
 	}
 
 	// Handle DELETE request
 	if err := h.service.DeleteVehicle(id); err != nil {
-		c.HTML(http.StatusInternalServerError, "mylist.html", gin.H{
-			"Error": err.Error(),
-		})
+		h.renderVehicle(c, errorStatus(err), vehicle, err.Error())
 		return
 	}
 
 	c.Redirect(http.StatusSeeOther, "/vehicles")
 }
 
-func (h *VehicleHandler) ChangeVehicleProcessToWashing(c *gin.Context) {
+func (h *VehicleHandler) TransitionVehicle(c *gin.Context) {
 	id := c.Param("id")
+	bayID, _ := strconv.ParseUint(c.PostForm("bay_id"), 10, 64)
+	req := services.TransitionRequest{
+		Process: c.PostForm("process"),
+		BayID:   uint(bayID),
+		Reason:  c.PostForm("reason"),
+		ActorID: currentUserID(c),
+	}
 
-	// Show confirmation form for GET requests
-	if c.Request.Method == http.MethodGet {
+	h.transition(c, id, req)
+}
 
-		var username string
-		claims := middleware.JwtClaims(c)
-		if claims != nil {
-			if usernameClaim, ok := claims["username"].(string); ok {
-				username = usernameClaim
-				if !strings.Contains(username, "@admin") {
-					c.HTML(http.StatusForbidden, "list.html", gin.H{
-						"Error": "Not authorized to delete this vehicle",
-					})
-					return
-				}
-			}
-		}
-		c.Redirect(http.StatusOK, "/vehicles")
-		return
+// PickupVehicle hands a finished vehicle back once its invoice is paid.
+// Managers may hand over an unpaid vehicle by giving a reason.
+func (h *VehicleHandler) PickupVehicle(c *gin.Context) {
+	id := c.Param("id")
+	req := services.TransitionRequest{
+		Process:         services.ProcessPickedUp,
+		Reason:          c.PostForm("reason"),
+		ActorID:         currentUserID(c),
+		OverridePayment: c.PostForm("override") == "true" && middleware.HasRole(c, managerRoles...),
 	}
+	h.transition(c, id, req)
+}
 
-	// Handle POST request to change process
-	if c.Request.Method == http.MethodPost {
-		if err := h.service.UpdateProcess(id, "Washing"); err != nil {
-			c.HTML(http.StatusInternalServerError, "edit.html", gin.H{
-				"Error": err.Error(),
-			})
+// transition moves the vehicle and shows it again, with the error if the move was refused
+func (h *VehicleHandler) transition(c *gin.Context, id string, req services.TransitionRequest) {
+	if err := h.service.TransitionVehicle(id, req); err != nil {
+		vehicle, findErr := h.service.GetVehicleByID(id)
+		if findErr != nil {
+			c.Redirect(http.StatusSeeOther, "/vehicles")
 			return
 		}
-
-		c.Redirect(http.StatusSeeOther, "/vehicles")
+		h.renderVehicle(c, errorStatus(err), vehicle, err.Error())
+		return
 	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/vehicles/%s", id))
 }
-func (h *VehicleHandler) ChangeVehicleProcessToFinish(c *gin.Context) {
-	id := c.Param("id")
 
-	// Show confirmation form for GET requests
-	if c.Request.Method == http.MethodGet {
+// Roles allowed to manage vehicles outright, to move them through the wash,
+// and to take payment and hand them back
+var (
+	managerRoles   = []string{repositories.RoleOwner, repositories.RoleAdmin}
+	washRoles      = []string{repositories.RoleOwner, repositories.RoleAdmin, repositories.RoleWasher}
+	frontDeskRoles = []string{repositories.RoleOwner, repositories.RoleAdmin, repositories.RoleCashier}
+)
 
-		var username string
-		claims := middleware.JwtClaims(c)
-		if claims != nil {
-			if usernameClaim, ok := claims["username"].(string); ok {
-				username = usernameClaim
-				if !strings.Contains(username, "@admin") {
-					c.HTML(http.StatusForbidden, "list.html", gin.H{
-						"Error": "Not authorized to delete this vehicle",
-					})
-					return
-				}
-			}
-		}
-		c.Redirect(http.StatusOK, "/vehicles")
-		return
+// canDeleteVehicle lets managers delete any vehicle and cashiers withdraw
+// their own check-in while it is still waiting
+func canDeleteVehicle(c *gin.Context, vehicle *repositories.Vehicle) bool {
+	if middleware.HasRole(c, managerRoles...) {
+		return true
 	}
+	return middleware.HasRole(c, repositories.RoleCashier) &&
+		vehicle.UserID == currentUserID(c) &&
+		vehicle.Process == services.ProcessWaiting
+}
 
-	// Handle POST request to change process
-	if c.Request.Method == http.MethodPost {
-		if err := h.service.UpdateProcess(id, "Finish"); err != nil {
-			c.HTML(http.StatusInternalServerError, "edit.html", gin.H{
-				"Error": err.Error(),
-			})
-			return
-		}
+// errorStatus maps service errors to the HTTP status they should be reported with
+func errorStatus(err error) int {
+	var transitionErr *services.TransitionError
+	if errors.As(err, &transitionErr) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, services.ErrNoBayAvailable) || errors.Is(err, repositories.ErrBayOccupied) || errors.Is(err, services.ErrPaidVisit) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, services.ErrUnpaid) || errors.Is(err, repositories.ErrInvoicePaid) || errors.Is(err, services.ErrNotRetryable) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, services.ErrReasonRequired) || errors.Is(err, repositories.ErrUnknownPackage) || errors.Is(err, repositories.ErrInvalidPlate) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, services.ErrOverrideReason) || errors.Is(err, services.ErrInvalidDiscount) || errors.Is(err, repositories.ErrInvalidPaymentMethod) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, repositories.ErrInvalidWebhookURL) || errors.Is(err, repositories.ErrUnknownWebhookEvent) {
+		return http.StatusBadRequest
+	}
+	if isNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
 
-		c.Redirect(http.StatusSeeOther, "/vehicles")
+// currentUserID returns the ID of the logged in user, or 0 for guests
+func currentUserID(c *gin.Context) uint {
+	claims := middleware.JwtClaims(c)
+	if claims == nil {
+		return 0
+	}
+	if idFloat, ok := claims["id"].(float64); ok {
+		return uint(idFloat)
 	}
+	return 0
 }
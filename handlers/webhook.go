@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"nevacarwash.com/main/repositories"
+	"nevacarwash.com/main/services"
+)
+
+type WebhookHandler struct {
+	service *services.WebhookService
+}
+
+func NewWebhookHandler(service *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: service}
+}
+
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	webhooks, err := h.service.GetWebhooks()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "webhooks.html", gin.H{"Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "webhooks.html", gin.H{
+		"Webhooks": webhooks,
+	})
+}
+
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		c.HTML(http.StatusOK, "webhookform.html", gin.H{
+			"Action": "/webhooks/new",
+			"Active": true,
+			"Events": services.WebhookEvents,
+		})
+		return
+	}
+
+	var input repositories.WebhookRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "webhookform.html", webhookFormData("/webhooks/new", input, err))
+		return
+	}
+
+	webhook, err := h.service.CreateWebhook(input)
+	if err != nil {
+		c.HTML(errorStatus(err), "webhookform.html", webhookFormData("/webhooks/new", input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/webhooks/%d", webhook.ID))
+}
+
+// GetWebhook shows the webhook with its secret and delivery log
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	h.renderWebhook(c, http.StatusOK, "")
+}
+
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/webhooks")
+		return
+	}
+	action := "/webhooks/" + c.Param("id") + "/edit"
+
+	// Show edit form for GET requests
+	if c.Request.Method == http.MethodGet {
+		webhook, err := h.service.GetWebhookByID(uint(id))
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/webhooks")
+			return
+		}
+		c.HTML(http.StatusOK, "webhookform.html", gin.H{
+			"Action":   action,
+			"URL":      webhook.URL,
+			"Selected": webhook.EventList(),
+			"Active":   webhook.Active,
+			"Events":   services.WebhookEvents,
+			"Editing":  true,
+		})
+		return
+	}
+
+	var input repositories.WebhookRequest
+	if err := c.ShouldBind(&input); err != nil {
+		c.HTML(http.StatusBadRequest, "webhookform.html", webhookFormData(action, input, err))
+		return
+	}
+
+	if err := h.service.UpdateWebhook(uint(id), input); err != nil {
+		c.HTML(errorStatus(err), "webhookform.html", webhookFormData(action, input, err))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/webhooks/"+c.Param("id"))
+}
+
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/webhooks")
+		return
+	}
+
+	if err := h.service.DeleteWebhook(uint(id)); err != nil {
+		c.HTML(errorStatus(err), "webhooks.html", gin.H{"Error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/webhooks")
+}
+
+// Redeliver sends an earlier payload again, e.g. after the receiver was fixed
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/webhooks")
+		return
+	}
+	deliveryID, err := strconv.ParseUint(c.Param("delivery"), 10, 64)
+	if err != nil {
+		h.renderWebhook(c, http.StatusBadRequest, "Invalid delivery")
+		return
+	}
+	if err := h.service.Redeliver(uint(id), uint(deliveryID)); err != nil {
+		h.renderWebhook(c, errorStatus(err), err.Error())
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/webhooks/"+c.Param("id"))
+}
+
+func (h *WebhookHandler) renderWebhook(c *gin.Context, status int, errMsg string) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/webhooks")
+		return
+	}
+	webhook, err := h.service.GetWebhookByID(uint(id))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/webhooks")
+		return
+	}
+	deliveries, err := h.service.GetDeliveries(webhook.ID)
+	if err != nil && errMsg == "" {
+		errMsg = err.Error()
+	}
+	c.HTML(status, "webhook.html", gin.H{
+		"Error":      errMsg,
+		"Webhook":    webhook,
+		"Deliveries": deliveries,
+	})
+}
+
+// webhookFormData refills the webhook form after a failed submit
+func webhookFormData(action string, input repositories.WebhookRequest, err error) gin.H {
+	return gin.H{
+		"Error":    err.Error(),
+		"Action":   action,
+		"URL":      input.URL,
+		"Selected": input.Events,
+		"Active":   input.Active,
+		"Events":   services.WebhookEvents,
+	}
+}
@@ -1,62 +1,97 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// tokenFromRequest reads the JWT from the Authorization cookie, or from an
+// "Authorization: Bearer" header for API clients. The API ignores the cookie
+// so other sites cannot call it with the user's browser.
+func tokenFromRequest(c *gin.Context) (string, bool) {
+	// A token renewed during this request wins over the expired one it replaces
+	if token := c.GetString(tokenKey); token != "" {
+		return token, true
+	}
+	if token, err := c.Cookie(accessCookie); err == nil && token != "" && !IsAPIRequest(c) {
+		return token, true
+	}
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer "), true
+	}
+	return "", false
+}
+
+// IsAPIRequest reports whether the request targets the JSON API
+func IsAPIRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/api/")
+}
+
+// deny stops the request, answering API clients with JSON and browsers with a redirect
+func deny(c *gin.Context, status int, message string, redirect string) {
+	if IsAPIRequest(c) {
+		c.AbortWithStatusJSON(status, gin.H{"error": gin.H{
+			"code":    strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+			"message": message,
+		}})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, redirect)
+	c.Abort()
+}
+
 func CheckAuth(c *gin.Context) {
 	if c.Request.URL.Path == "/login" || c.Request.URL.Path == "/register" {
 		c.Next()
 		return
 	}
 
-	// Get token from cookie instead of header
-	token, err := c.Cookie("Authorization")
-	if err != nil {
-		c.Redirect(http.StatusSeeOther, "/login")
-		c.Abort()
-		return
+	// Get token from cookie, or from the header for API clients
+	token, ok := tokenFromRequest(c)
+	if ok {
+		if claims, err := parseToken(token); err == nil && sessionActive(claims) {
+			c.Next()
+			return
+		}
 	}
 
-	// Validate token
-	claims := jwt.MapClaims{}
-	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("SECRET")), nil
-	})
-
-	if err != nil {
-		c.Redirect(http.StatusSeeOther, "/login")
-		c.Abort()
-		return
+	// Browsers renew a short lived access token from their refresh cookie,
+	// API clients call /api/v1/refresh themselves
+	if !IsAPIRequest(c) {
+		if refreshSession(c) {
+			c.Next()
+			return
+		}
+		ClearSessionCookies(c)
 	}
-
-	c.Next()
+	deny(c, http.StatusUnauthorized, "Unauthorized", "/login")
 }
 
+// JwtClaims returns the claims of the caller's token, or nil when it has none or it is not valid
 func JwtClaims(c *gin.Context) jwt.MapClaims {
-	token, err := c.Cookie("Authorization")
+	token, ok := tokenFromRequest(c)
+	if !ok {
+		return nil
+	}
+	claims, err := parseToken(token)
 	if err != nil {
 		return nil
 	}
-	claims := jwt.MapClaims{}
-	jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("SECRET")), nil
-	})
 	return claims
 }
 
-// New function to retrieve admin attribute
-func IsAdmin(c *gin.Context) bool {
-	claims := JwtClaims(c)
-	if claims == nil {
-		return false
-	}
-	if admin, ok := claims["admin"].(bool); ok {
-		return admin
-	}
-	return false
+func parseToken(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("SECRET")), nil
+	})
+	return claims, err
 }
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+const (
+	csrfCookie    = "csrf"
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+	csrfKey       = "csrfToken"
+	csrfBytes     = 32
+)
+
+// CSRF gives every browser a token that its form posts have to send back, so
+// other sites cannot submit forms with the user's cookies. The JSON API is
+// left alone as it only accepts bearer tokens, which browsers never send on
+// their own.
+func CSRF(c *gin.Context) {
+	token, err := c.Cookie(csrfCookie)
+	c.Writer = &csrfWriter{ResponseWriter: c.Writer, token: token}
+	if err != nil || len(token) != base64.RawURLEncoding.EncodedLen(csrfBytes) {
+		token = RenewCSRFToken(c)
+	}
+	c.Set(csrfKey, token)
+
+	if IsAPIRequest(c) || !changesState(c.Request.Method) {
+		c.Next()
+		return
+	}
+
+	sent := c.PostForm(csrfFormField)
+	if sent == "" {
+		sent = c.GetHeader(csrfHeader)
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		c.HTML(http.StatusForbidden, "csrf.html", gin.H{
+			"Error": "This form has expired or was sent from another site. Go back, reload the page and try again.",
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// CSRFToken returns the token forms of this request have to send
+func CSRFToken(c *gin.Context) string {
+	return c.GetString(csrfKey)
+}
+
+// CSRFField is the csrfField template function outside of a request, the
+// real token is filled in by the renderer from NewCSRFRender
+func CSRFField() template.HTML {
+	return ""
+}
+
+// RenewCSRFToken starts a new token, on first visit and whenever the user signs in or out
+func RenewCSRFToken(c *gin.Context) string {
+	b := make([]byte, csrfBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(csrfCookie, token, 0, "/", "", CookieSecure, true)
+	c.Set(csrfKey, token)
+	if w, ok := c.Writer.(*csrfWriter); ok {
+		w.token = token
+	}
+	return token
+}
+
+func changesState(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// csrfWriter carries the request's token to the template renderer
+type csrfWriter struct {
+	gin.ResponseWriter
+	token string
+}
+
+// NewCSRFRender wraps the template renderer so {{csrfField}} in a template
+// writes the hidden input holding the request's token
+func NewCSRFRender(r render.HTMLRender) render.HTMLRender {
+	return csrfRender{HTMLRender: r}
+}
+
+type csrfRender struct {
+	render.HTMLRender
+}
+
+func (r csrfRender) Instance(name string, data any) render.Render {
+	return csrfHTML{inner: r.HTMLRender.Instance(name, data)}
+}
+
+type csrfHTML struct {
+	inner render.Render
+}
+
+func (h csrfHTML) WriteContentType(w http.ResponseWriter) {
+	h.inner.WriteContentType(w)
+}
+
+func (h csrfHTML) Render(w http.ResponseWriter) error {
+	html, ok := h.inner.(render.HTML)
+	writer, hasToken := w.(*csrfWriter)
+	if !ok || html.Template == nil || !hasToken {
+		return h.inner.Render(w)
+	}
+	// The shared templates are never executed themselves, so each request can
+	// clone them with its own token
+	t, err := html.Template.Clone()
+	if err != nil {
+		return err
+	}
+	field := template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(writer.token) + `" />`)
+	t.Funcs(template.FuncMap{"csrfField": func() template.HTML { return field }})
+	html.Template = t
+	return html.Render(w)
+}
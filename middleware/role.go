@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CurrentRole returns the role carried in the caller's token, or "" for guests
+func CurrentRole(c *gin.Context) string {
+	claims := JwtClaims(c)
+	if claims == nil {
+		return ""
+	}
+	role, _ := claims["role"].(string)
+	return role
+}
+
+// HasRole reports whether the caller holds one of the given roles
+func HasRole(c *gin.Context, roles ...string) bool {
+	current := CurrentRole(c)
+	if current == "" {
+		return false
+	}
+	for _, role := range roles {
+		if role == current {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole only lets through callers holding one of the given roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasRole(c, roles...) {
+			deny(c, http.StatusForbidden, "Your role does not allow this action", "/vehicles")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"nevacarwash.com/main/services"
+)
+
+const (
+	accessCookie  = "Authorization"
+	refreshCookie = "Refresh"
+	// roleCookie is readable by the page scripts so the header can hide links the role cannot use
+	roleCookie = "Role"
+	tokenKey   = "accessToken"
+	// challengeCookie carries an accepted password to the second login step
+	challengeCookie = "TwoFactor"
+	challengePath   = "/login"
+)
+
+// CookieSecure marks the session cookies Secure, turn it off only when serving plain HTTP during development
+var CookieSecure = true
+
+// SessionStore checks that signed in devices have not been signed out, and
+// renews their access tokens
+type SessionStore interface {
+	IsActive(sessionID uint) bool
+	Refresh(refreshToken, ip string) (*services.SessionTokens, error)
+}
+
+var sessions SessionStore
+
+// UseSessions makes CheckAuth only accept tokens of sessions the store still holds
+func UseSessions(store SessionStore) {
+	sessions = store
+}
+
+// SetSessionCookies hands the browser its tokens. The tokens are HttpOnly so
+// page scripts never see them, and each cookie lasts as long as its token.
+func SetSessionCookies(c *gin.Context, tokens *services.SessionTokens) {
+	maxAge := int(time.Until(tokens.ExpiresAt).Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(accessCookie, tokens.AccessToken, int(time.Until(tokens.AccessExpiresAt).Seconds()), "/", "", CookieSecure, true)
+	if tokens.RefreshToken != "" {
+		c.SetCookie(refreshCookie, tokens.RefreshToken, maxAge, "/", "", CookieSecure, true)
+	}
+	c.SetCookie(roleCookie, tokens.Role, maxAge, "/", "", CookieSecure, false)
+	c.Set(tokenKey, tokens.AccessToken)
+}
+
+// ClearSessionCookies removes the tokens from the browser, forms opened while
+// signed in stop working with them
+func ClearSessionCookies(c *gin.Context) {
+	RenewCSRFToken(c)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(accessCookie, "", -1, "/", "", CookieSecure, true)
+	c.SetCookie(refreshCookie, "", -1, "/", "", CookieSecure, true)
+	c.SetCookie(roleCookie, "", -1, "/", "", CookieSecure, false)
+}
+
+// SetLoginChallenge remembers that the browser got the password right until
+// it enters its code
+func SetLoginChallenge(c *gin.Context, token string, expiresAt time.Time) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(challengeCookie, token, int(time.Until(expiresAt).Seconds()), challengePath, "", CookieSecure, true)
+}
+
+// LoginChallenge returns the browser's pending login challenge, or "" when it has none
+func LoginChallenge(c *gin.Context) string {
+	token, _ := c.Cookie(challengeCookie)
+	return token
+}
+
+// ClearLoginChallenge ends the second login step
+func ClearLoginChallenge(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(challengeCookie, "", -1, challengePath, "", CookieSecure, true)
+}
+
+// CurrentSessionID returns the session the caller's token belongs to, or 0 when there is none
+func CurrentSessionID(c *gin.Context) uint {
+	claims := JwtClaims(c)
+	if claims == nil {
+		return 0
+	}
+	if sid, ok := claims["sid"].(float64); ok {
+		return uint(sid)
+	}
+	return 0
+}
+
+// sessionActive reports whether the session of the token has not been signed out
+func sessionActive(claims jwt.MapClaims) bool {
+	if sessions == nil {
+		return true
+	}
+	sid, ok := claims["sid"].(float64)
+	return ok && sessions.IsActive(uint(sid))
+}
+
+// refreshSession renews an expired access token from the browser's refresh cookie
+func refreshSession(c *gin.Context) bool {
+	if sessions == nil {
+		return false
+	}
+	refreshToken, err := c.Cookie(refreshCookie)
+	if err != nil || refreshToken == "" {
+		return false
+	}
+	tokens, err := sessions.Refresh(refreshToken, c.ClientIP())
+	if err != nil {
+		return false
+	}
+	SetSessionCookies(c, tokens)
+	return true
+}
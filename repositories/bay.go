@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type Bay struct {
+	ID             uint   `json:"id" gorm:"primaryKey"`
+	Name           string `json:"name" gorm:"unique"`
+	VehicleClasses string `json:"vehicle_classes"` // Comma separated list of supported classes
+	Active         bool   `json:"active"`
+}
+
+type BayRequest struct {
+	Name           string   `form:"name" binding:"required"`
+	VehicleClasses []string `form:"vehicle_classes" binding:"required"`
+	Active         bool     `form:"active"`
+}
+
+// Classes returns the vehicle classes this bay can wash
+func (b *Bay) Classes() []string {
+	if b.VehicleClasses == "" {
+		return nil
+	}
+	return strings.Split(b.VehicleClasses, ",")
+}
+
+func (b *Bay) Supports(class string) bool {
+	for _, supported := range b.Classes() {
+		if supported == class {
+			return true
+		}
+	}
+	return false
+}
+
+type BayRepository struct {
+	db *gorm.DB
+}
+
+func NewBayRepository(db *gorm.DB) *BayRepository {
+	return &BayRepository{db: db}
+}
+
+func (r *BayRepository) Create(input *BayRequest) (*Bay, error) {
+	bay := Bay{
+		Name:           input.Name,
+		VehicleClasses: strings.Join(input.VehicleClasses, ","),
+		Active:         input.Active,
+	}
+	return &bay, r.db.Create(&bay).Error
+}
+
+func (r *BayRepository) FindAll() ([]Bay, error) {
+	var bays []Bay
+	err := r.db.Order("name").Find(&bays).Error
+	return bays, err
+}
+
+func (r *BayRepository) FindActive() ([]Bay, error) {
+	var bays []Bay
+	err := r.db.Where("active = ?", true).Order("name").Find(&bays).Error
+	return bays, err
+}
+
+func (r *BayRepository) FindByID(id uint) (*Bay, error) {
+	var bay Bay
+	err := r.db.First(&bay, id).Error
+	return &bay, err
+}
+
+// FindFree returns the active bays that have no vehicle being washed in them
+func (r *BayRepository) FindFree() ([]Bay, error) {
+	var bays []Bay
+	err := r.db.
+		Where("active = ?", true).
+		Where("id NOT IN (?)", r.db.Model(&Vehicle{}).Select("bay_id").Where("process = ? AND bay_id IS NOT NULL", "Washing")).
+		Order("name").
+		Find(&bays).Error
+	return bays, err
+}
+
+func (r *BayRepository) Update(id uint, input *BayRequest) error {
+	var existingBay Bay
+	if err := r.db.First(&existingBay, id).Error; err != nil {
+		return err
+	}
+
+	existingBay.Name = input.Name
+	existingBay.VehicleClasses = strings.Join(input.VehicleClasses, ",")
+	existingBay.Active = input.Active
+
+	return r.db.Save(&existingBay).Error
+}
+
+func (r *BayRepository) Delete(id uint) error {
+	return r.db.Delete(&Bay{}, id).Error
+}
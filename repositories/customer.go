@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Customer is the person bringing a vehicle in, as opposed to the staff user recording it
+type Customer struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name"`
+	Phone     string    `json:"phone" gorm:"uniqueIndex"` // Normalized, see NormalizePhone
+	Email     string    `json:"email"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CustomerRequest struct {
+	Name  string `form:"name" binding:"required"`
+	Phone string `form:"phone" binding:"required"`
+	Email string `form:"email"`
+	Notes string `form:"notes"`
+}
+
+var (
+	ErrInvalidPhone   = errors.New("phone number is not valid")
+	ErrDuplicatePhone = errors.New("another customer already uses this phone number")
+)
+
+// NormalizePhone reduces a phone number to its digits so "+62 812-345" and
+// "0812345" match. It returns "" when the text does not look like a phone number.
+func NormalizePhone(phone string) string {
+	normalized := phoneDigits(phone)
+	if strings.HasPrefix(normalized, "62") {
+		normalized = "0" + strings.TrimPrefix(normalized, "62")
+	}
+	if len(normalized) < 6 {
+		return ""
+	}
+	return normalized
+}
+
+// phoneDigits keeps only the digits of a phone number
+func phoneDigits(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	return digits.String()
+}
+
+type CustomerRepository struct {
+	db *gorm.DB
+}
+
+func NewCustomerRepository(db *gorm.DB) *CustomerRepository {
+	return &CustomerRepository{db: db}
+}
+
+func (r *CustomerRepository) FindByID(id uint) (*Customer, error) {
+	var customer Customer
+	err := r.db.First(&customer, id).Error
+	return &customer, err
+}
+
+func (r *CustomerRepository) FindByPhone(phone string) (*Customer, error) {
+	var customer Customer
+	err := r.db.Where("phone = ?", NormalizePhone(phone)).First(&customer).Error
+	return &customer, err
+}
+
+// Search matches customers by part of their name or phone number
+func (r *CustomerRepository) Search(query string, limit int) ([]Customer, error) {
+	var customers []Customer
+	db := r.db.Order("name").Limit(limit)
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := "%" + strings.ToUpper(query) + "%"
+		if phone := phoneDigits(query); phone != "" {
+			db = db.Where("UPPER(name) LIKE ? OR phone LIKE ?", pattern, "%"+phone+"%")
+		} else {
+			db = db.Where("UPPER(name) LIKE ?", pattern)
+		}
+	}
+	err := db.Find(&customers).Error
+	return customers, err
+}
+
+// FindOrCreateByPhone returns the customer with the given phone number,
+// creating one with the given name when there is none yet
+func (r *CustomerRepository) FindOrCreateByPhone(name, phone string) (*Customer, error) {
+	normalized := NormalizePhone(phone)
+	if normalized == "" {
+		return nil, ErrInvalidPhone
+	}
+
+	// Concurrent check-ins for a new customer race on the unique phone index
+	customer := Customer{Name: name, Phone: normalized}
+	err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&customer).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByPhone(normalized)
+}
+
+func (r *CustomerRepository) Update(id uint, input *CustomerRequest) error {
+	var existingCustomer Customer
+	if err := r.db.First(&existingCustomer, id).Error; err != nil {
+		return err
+	}
+
+	phone := NormalizePhone(input.Phone)
+	if phone == "" {
+		return ErrInvalidPhone
+	}
+	var duplicates int64
+	if err := r.db.Model(&Customer{}).Where("phone = ? AND id <> ?", phone, id).Count(&duplicates).Error; err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		return ErrDuplicatePhone
+	}
+
+	existingCustomer.Name = input.Name
+	existingCustomer.Phone = phone
+	existingCustomer.Email = input.Email
+	existingCustomer.Notes = input.Notes
+
+	return r.db.Save(&existingCustomer).Error
+}
@@ -0,0 +1,35 @@
+package repositories_test
+
+import (
+	"testing"
+
+	"nevacarwash.com/main/repositories"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		name  string
+		phone string
+		want  string
+	}{
+		{"international mobile", "+62 812-3456-7890", "081234567890"},
+		{"local mobile", "0812 3456 7890", "081234567890"},
+		{"country code without plus", "6281234567890", "081234567890"},
+		{"dotted mobile", "0812.3456.7890", "081234567890"},
+		{"labelled number", "Telp: 0812 3456 7890", "081234567890"},
+		{"jakarta landline", "(021) 555-1234", "0215551234"},
+		{"international landline", "+62-21-5551234", "0215551234"},
+		{"shortest accepted", "081234", "081234"},
+		{"too short", "0812-3", ""},
+		{"country code only", "+62 1", ""},
+		{"no digits", "tidak ada", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repositories.NormalizePhone(tt.phone); got != tt.want {
+				t.Errorf("NormalizePhone(%q) = %q, want %q", tt.phone, got, tt.want)
+			}
+		})
+	}
+}
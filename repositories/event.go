@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventCreated    = "created"
+	EventUpdated    = "updated"
+	EventTransition = "transition"
+	EventCarried    = "carried"
+)
+
+type VehicleEvent struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	VehicleID   string    `json:"vehicle_id" gorm:"index"`
+	ActorID     uint      `json:"actor_id"`
+	Actor       User      `json:"-" gorm:"foreignKey:ActorID"`
+	Type        string    `json:"type"`
+	FromProcess string    `json:"from_process"`
+	ToProcess   string    `json:"to_process"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type VehicleEventRepository struct {
+	db *gorm.DB
+}
+
+func NewVehicleEventRepository(db *gorm.DB) *VehicleEventRepository {
+	return &VehicleEventRepository{db: db}
+}
+
+func (r *VehicleEventRepository) Create(event *VehicleEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *VehicleEventRepository) FindByVehicleID(vehicleID string) ([]VehicleEvent, error) {
+	var events []VehicleEvent
+	err := r.db.Where("vehicle_id = ?", vehicleID).Order("created_at, id").Preload("Actor").Find(&events).Error
+	return events, err
+}
+
+// FindLastTransition returns the most recent move of a vehicle into the given process
+func (r *VehicleEventRepository) FindLastTransition(vehicleID string, to string) (*VehicleEvent, error) {
+	var event VehicleEvent
+	err := r.db.Where("vehicle_id = ? AND to_process = ? AND from_process <> ?", vehicleID, to, to).
+		Order("created_at DESC, id DESC").
+		First(&event).Error
+	return &event, err
+}
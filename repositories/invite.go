@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invite lets one person register a staff account with the role a manager
+// picked for them. The link they receive holds a token stored only as a hash.
+type Invite struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex"`
+	CreatedByID uint       `json:"created_by_id"`
+	CreatedBy   User       `json:"-" gorm:"foreignKey:CreatedByID"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Usable reports whether the invite can still be used to register
+func (i *Invite) Usable(now time.Time) bool {
+	return i.UsedAt == nil && now.Before(i.ExpiresAt)
+}
+
+type InviteRequest struct {
+	Email string `form:"email" binding:"required"`
+	Role  string `form:"role" binding:"required"`
+}
+
+type InviteRepository struct {
+	db *gorm.DB
+}
+
+func NewInviteRepository(db *gorm.DB) *InviteRepository {
+	return &InviteRepository{db: db}
+}
+
+func (r *InviteRepository) Create(invite *Invite) error {
+	return r.db.Create(invite).Error
+}
+
+func (r *InviteRepository) FindByTokenHash(hash string) (*Invite, error) {
+	var invite Invite
+	err := r.db.Where("token_hash = ?", hash).First(&invite).Error
+	return &invite, err
+}
+
+// FindPending returns the invites that have not been used and have not expired, newest first
+func (r *InviteRepository) FindPending(now time.Time) ([]Invite, error) {
+	var invites []Invite
+	err := r.db.
+		Where("used_at IS NULL AND expires_at > ?", now.UTC()).
+		Preload("CreatedBy").
+		Order("id DESC").
+		Find(&invites).Error
+	return invites, err
+}
+
+// Use creates the invited user and uses up the invite in one go, so an invite
+// link only ever registers one account
+func (r *InviteRepository) Use(invite *Invite, user *User, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Invite{}).Where("id = ? AND used_at IS NULL", invite.ID).Update("used_at", now.UTC())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(user).Error
+	})
+}
+
+func (r *InviteRepository) Delete(id uint) error {
+	result := r.db.Where("used_at IS NULL").Delete(&Invite{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
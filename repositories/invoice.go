@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	InvoiceUnpaid = "unpaid"
+	InvoicePaid   = "paid"
+)
+
+// Line item kinds, the package line follows the vehicle's package
+const (
+	ItemPackage = "package"
+	ItemAddOn   = "addon"
+)
+
+// PaymentMethods lists the ways a customer can pay
+var PaymentMethods = []string{"cash", "qris", "debit", "transfer"}
+
+func ValidPaymentMethod(method string) bool {
+	for _, known := range PaymentMethods {
+		if known == method {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ErrInvoicePaid          = errors.New("invoice is already paid")
+	ErrInvalidPaymentMethod = errors.New("unknown payment method")
+)
+
+// Invoice is the bill for one wash visit. Amounts are whole rupiah.
+type Invoice struct {
+	ID            uint          `json:"id" gorm:"primaryKey"`
+	Number        string        `json:"number" gorm:"uniqueIndex"`
+	VehicleID     string        `json:"vehicle_id" gorm:"uniqueIndex"`
+	Items         []InvoiceItem `json:"items" gorm:"foreignKey:InvoiceID"`
+	Subtotal      int64         `json:"subtotal"`
+	Discount      int64         `json:"discount"`
+	DiscountRate  float64       `json:"discount_rate"` // Percentage discounts follow the subtotal
+	DiscountNote  string        `json:"discount_note"`
+	TaxPercent    float64       `json:"tax_percent"` // Rate when the invoice was opened
+	Tax           int64         `json:"tax"`
+	Total         int64         `json:"total"`
+	Status        string        `json:"status" gorm:"index"`
+	PaymentMethod string        `json:"payment_method"`
+	Reference     string        `json:"reference"` // QRIS, card or transfer reference
+	PaidAt        *time.Time    `json:"paid_at"`
+	PaidByID      *uint         `json:"paid_by_id"`
+	PaidBy        *User         `json:"-" gorm:"foreignKey:PaidByID"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+type InvoiceItem struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	InvoiceID   uint   `json:"invoice_id" gorm:"index"`
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	Amount      int64  `json:"amount"`
+}
+
+type InvoiceItemRequest struct {
+	Description string `form:"description" binding:"required"`
+	Quantity    int    `form:"quantity" binding:"required,min=1"`
+	UnitPrice   int64  `form:"unit_price" binding:"min=0"`
+}
+
+type PaymentRequest struct {
+	Method    string `form:"method" binding:"required"`
+	Reference string `form:"reference"`
+}
+
+// InvoiceSequence names the counter behind the invoice numbers of a business day
+func InvoiceSequence(date time.Time) string {
+	return "invoice:" + date.In(BusinessLocation).Format("2006-01-02")
+}
+
+type InvoiceRepository struct {
+	db *gorm.DB
+}
+
+func NewInvoiceRepository(db *gorm.DB) *InvoiceRepository {
+	return &InvoiceRepository{db: db}
+}
+
+func (r *InvoiceRepository) FindByVehicleID(vehicleID string) (*Invoice, error) {
+	var invoice Invoice
+	err := r.db.Where("vehicle_id = ?", vehicleID).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("PaidBy").
+		First(&invoice).Error
+	return &invoice, err
+}
+
+// Create numbers the invoice INV-YYYYMMDD-N and stores it with its items
+func (r *InvoiceRepository) Create(invoice *Invoice) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		today := Today()
+		n, err := nextSequence(tx, InvoiceSequence(today))
+		if err != nil {
+			return err
+		}
+		invoice.Number = fmt.Sprintf("INV-%s-%04d", today.In(BusinessLocation).Format("20060102"), n)
+		invoice.Status = InvoiceUnpaid
+		return tx.Create(invoice).Error
+	})
+}
+
+// SaveItems replaces the items of an unpaid invoice and stores its totals
+func (r *InvoiceRepository) SaveItems(invoice *Invoice) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("invoice_id = ?", invoice.ID).Delete(&InvoiceItem{}).Error; err != nil {
+			return err
+		}
+		for i := range invoice.Items {
+			invoice.Items[i].ID = 0
+			invoice.Items[i].InvoiceID = invoice.ID
+		}
+		if len(invoice.Items) > 0 {
+			if err := tx.Create(&invoice.Items).Error; err != nil {
+				return err
+			}
+		}
+		return saveInvoiceTotals(tx, invoice)
+	})
+}
+
+// saveInvoiceTotals stores the amounts of an invoice as long as it is still unpaid
+func saveInvoiceTotals(tx *gorm.DB, invoice *Invoice) error {
+	result := tx.Model(&Invoice{}).
+		Where("id = ? AND status = ?", invoice.ID, InvoiceUnpaid).
+		Updates(map[string]interface{}{
+			"subtotal":      invoice.Subtotal,
+			"discount":      invoice.Discount,
+			"discount_rate": invoice.DiscountRate,
+			"discount_note": invoice.DiscountNote,
+			"tax":           invoice.Tax,
+			"total":         invoice.Total,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvoicePaid
+	}
+	return nil
+}
+
+// MarkPaid settles an unpaid invoice. Two cashiers taking the same payment
+// race on the status, the second one gets ErrInvoicePaid.
+func (r *InvoiceRepository) MarkPaid(id uint, input *PaymentRequest, paidByID uint) error {
+	now := time.Now().UTC()
+	result := r.db.Model(&Invoice{}).
+		Where("id = ? AND status = ?", id, InvoiceUnpaid).
+		Updates(map[string]interface{}{
+			"status":         InvoicePaid,
+			"payment_method": input.Method,
+			"reference":      input.Reference,
+			"paid_at":        now,
+			"paid_by_id":     paidByID,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvoicePaid
+	}
+	return nil
+}
+
+// deleteUnpaidInvoice removes the unpaid invoice of a vehicle within tx. Paid
+// invoices belong to the accounts, finding one returns ErrInvoicePaid so the
+// caller rolls back.
+func deleteUnpaidInvoice(tx *gorm.DB, vehicleID string) error {
+	var ids []uint
+	if err := tx.Model(&Invoice{}).Where("vehicle_id = ?", vehicleID).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// Only unpaid rows are deleted, an invoice paid meanwhile is left and rolls the delete back
+	result := tx.Where("id IN ? AND status = ?", ids, InvoiceUnpaid).Delete(&Invoice{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(ids)) {
+		return ErrInvoicePaid
+	}
+	return tx.Where("invoice_id IN ?", ids).Delete(&InvoiceItem{}).Error
+}
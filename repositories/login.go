@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Outcomes of a login attempt, as recorded in the audit log
+const (
+	LoginSucceeded   = "success"
+	LoginBadPassword = "bad_password"
+	LoginUnknownUser = "unknown_user"
+	LoginLocked      = "locked"
+	LoginThrottled   = "throttled"
+	LoginNeedsCode   = "code_required" // Right password, waiting for the authenticator code
+	LoginBadCode     = "bad_code"
+)
+
+// LoginAttempt is an audit entry for one try at signing in
+type LoginAttempt struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Username  string    `json:"username" gorm:"index"`
+	UserID    *uint     `json:"user_id" gorm:"index"`
+	IP        string    `json:"ip" gorm:"index"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+func (r *LoginAttemptRepository) Create(attempt *LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+// FindRecent returns the latest attempts, newest first
+func (r *LoginAttemptRepository) FindRecent(limit int) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := r.db.Order("id DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
+
+// FailuresByIP counts the wrong passwords and codes sent from the address
+// since the given time, and when the last one came in
+func (r *LoginAttemptRepository) FailuresByIP(ip string, since time.Time) (int, time.Time, error) {
+	var attempts []LoginAttempt
+	err := r.db.
+		Where("ip = ? AND outcome IN ? AND created_at > ?", ip, []string{LoginBadPassword, LoginUnknownUser, LoginBadCode}, since.UTC()).
+		Order("id DESC").
+		Find(&attempts).Error
+	if err != nil || len(attempts) == 0 {
+		return 0, time.Time{}, err
+	}
+	return len(attempts), attempts[0].CreatedAt, nil
+}
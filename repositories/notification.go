@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	NotificationPending = "pending"
+	NotificationSending = "sending" // A retry claimed it and is delivering
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// MessageTemplate is the message sent to the customer when their vehicle
+// moves to Process. Placeholders like {plate} are filled in when sending.
+type MessageTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Process   string    `json:"process" gorm:"uniqueIndex"`
+	Body      string    `json:"body"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type MessageTemplateRequest struct {
+	Body    string `form:"body" binding:"required"`
+	Enabled bool   `form:"enabled"`
+}
+
+// Notification is one message to a customer and how its delivery went
+type Notification struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	VehicleID string     `json:"vehicle_id" gorm:"index"`
+	Process   string     `json:"process"` // Transition that triggered the message
+	Recipient string     `json:"recipient"`
+	Message   string     `json:"message"`
+	Status    string     `json:"status" gorm:"index"`
+	Attempts  int        `json:"attempts"`
+	Error     string     `json:"error"`     // Why the last attempt failed
+	Reference string     `json:"reference"` // Message ID given by the gateway
+	SentAt    *time.Time `json:"sent_at"`
+	ClaimedAt *time.Time `json:"claimed_at"` // When the current attempt started, while pending or sending
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type NotificationRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+func (r *NotificationRepository) FindTemplates() ([]MessageTemplate, error) {
+	var templates []MessageTemplate
+	err := r.db.Order("id").Find(&templates).Error
+	return templates, err
+}
+
+// FindTemplate returns the message for vehicles moving to the process
+func (r *NotificationRepository) FindTemplate(process string) (*MessageTemplate, error) {
+	var template MessageTemplate
+	err := r.db.Where("process = ?", process).First(&template).Error
+	return &template, err
+}
+
+func (r *NotificationRepository) UpdateTemplate(id uint, input *MessageTemplateRequest) error {
+	var template MessageTemplate
+	if err := r.db.First(&template, id).Error; err != nil {
+		return err
+	}
+	template.Body = input.Body
+	template.Enabled = input.Enabled
+	return r.db.Save(&template).Error
+}
+
+func (r *NotificationRepository) Create(notification *Notification) error {
+	return r.db.Create(notification).Error
+}
+
+func (r *NotificationRepository) FindByID(id uint) (*Notification, error) {
+	var notification Notification
+	err := r.db.First(&notification, id).Error
+	return &notification, err
+}
+
+// FindByVehicleID returns the delivery log of a vehicle, oldest first
+func (r *NotificationRepository) FindByVehicleID(vehicleID string) ([]Notification, error) {
+	var notifications []Notification
+	err := r.db.Where("vehicle_id = ?", vehicleID).Order("created_at, id").Find(&notifications).Error
+	return notifications, err
+}
+
+// FindRetryable returns notifications with attempts left that failed before
+// the given time, or whose attempt was claimed before staleBefore and never finished
+func (r *NotificationRepository) FindRetryable(maxAttempts int, before, staleBefore time.Time) ([]Notification, error) {
+	var notifications []Notification
+	err := r.db.
+		Where("attempts < ?", maxAttempts).
+		Where(r.db.Where("status = ? AND updated_at < ?", NotificationFailed, before).
+			Or("status IN ? AND claimed_at < ?", []string{NotificationPending, NotificationSending}, staleBefore)).
+		Order("id").
+		Find(&notifications).Error
+	return notifications, err
+}
+
+// ClaimRetry moves a failed notification, or one whose attempt was claimed
+// before staleBefore and never finished, to sending. It reports false when
+// someone else claimed it first, only the winner may deliver it.
+func (r *NotificationRepository) ClaimRetry(id uint, now, staleBefore time.Time) (bool, error) {
+	result := r.db.Model(&Notification{}).
+		Where("id = ?", id).
+		Where(r.db.Where("status = ?", NotificationFailed).
+			Or("status IN ? AND claimed_at < ?", []string{NotificationPending, NotificationSending}, staleBefore.UTC())).
+		Updates(map[string]interface{}{
+			"status":     NotificationSending,
+			"claimed_at": now.UTC(),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *NotificationRepository) Save(notification *Notification) error {
+	return r.db.Save(notification).Error
+}
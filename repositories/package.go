@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"gorm.io/gorm"
+)
+
+// VehicleClasses lists the vehicle classes a package can be offered for.
+var VehicleClasses = []string{"Motor", "Motor Besar", "Mobil", "Mobil Besar"}
+
+type Package struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	Name         string `json:"name" gorm:"unique"`
+	Duration     int    `json:"duration"` // Wash duration in minutes
+	Price        int64  `json:"price"`
+	VehicleClass string `json:"vehicle_class"`
+	Active       bool   `json:"active"`
+}
+
+type PackageRequest struct {
+	Name         string `form:"name" binding:"required"`
+	Duration     int    `form:"duration" binding:"required,min=1"`
+	Price        int64  `form:"price" binding:"min=0"`
+	VehicleClass string `form:"vehicle_class" binding:"required"`
+	Active       bool   `form:"active"`
+}
+
+type PackageRepository struct {
+	db *gorm.DB
+}
+
+func NewPackageRepository(db *gorm.DB) *PackageRepository {
+	return &PackageRepository{db: db}
+}
+
+func (r *PackageRepository) Create(input *PackageRequest) (*Package, error) {
+	pkg := Package{
+		Name:         input.Name,
+		Duration:     input.Duration,
+		Price:        input.Price,
+		VehicleClass: input.VehicleClass,
+		Active:       input.Active,
+	}
+	return &pkg, r.db.Create(&pkg).Error
+}
+
+func (r *PackageRepository) FindAll() ([]Package, error) {
+	var packages []Package
+	err := r.db.Order("name").Find(&packages).Error
+	return packages, err
+}
+
+func (r *PackageRepository) FindActive() ([]Package, error) {
+	var packages []Package
+	err := r.db.Where("active = ?", true).Order("name").Find(&packages).Error
+	return packages, err
+}
+
+func (r *PackageRepository) FindByID(id uint) (*Package, error) {
+	var pkg Package
+	err := r.db.First(&pkg, id).Error
+	return &pkg, err
+}
+
+func (r *PackageRepository) FindByName(name string) (*Package, error) {
+	var pkg Package
+	err := r.db.Where("name = ?", name).First(&pkg).Error
+	return &pkg, err
+}
+
+func (r *PackageRepository) Update(id uint, input *PackageRequest) error {
+	var existingPackage Package
+	if err := r.db.First(&existingPackage, id).Error; err != nil {
+		return err
+	}
+
+	existingPackage.Name = input.Name
+	existingPackage.Duration = input.Duration
+	existingPackage.Price = input.Price
+	existingPackage.VehicleClass = input.VehicleClass
+	existingPackage.Active = input.Active
+
+	return r.db.Save(&existingPackage).Error
+}
+
+func (r *PackageRepository) Delete(id uint) error {
+	return r.db.Delete(&Package{}, id).Error
+}
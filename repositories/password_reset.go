@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordReset lets a user pick a new password through a link a manager
+// had sent to them. The token in the link is stored only as a hash.
+type PasswordReset struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"index"`
+	User        User       `json:"-" gorm:"foreignKey:UserID"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex"`
+	CreatedByID uint       `json:"created_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Usable reports whether the link can still be used to set a password
+func (p *PasswordReset) Usable(now time.Time) bool {
+	return p.UsedAt == nil && now.Before(p.ExpiresAt)
+}
+
+type PasswordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+// Create stores a new reset link for the user, earlier links stop working
+func (r *PasswordResetRepository) Create(reset *PasswordReset) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("expires_at", time.Now().UTC()).Error; err != nil {
+			return err
+		}
+		return tx.Create(reset).Error
+	})
+}
+
+func (r *PasswordResetRepository) FindByTokenHash(hash string) (*PasswordReset, error) {
+	var reset PasswordReset
+	err := r.db.Where("token_hash = ?", hash).Preload("User").First(&reset).Error
+	return &reset, err
+}
+
+// Use sets the user's new password hash and uses up the link in one go
+func (r *PasswordResetRepository) Use(reset *PasswordReset, passwordHash string, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", now.UTC())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&User{}).Where("id = ?", reset.UserID).Updates(map[string]interface{}{
+			"password":      passwordHash,
+			"failed_logins": 0,
+			"locked_until":  nil,
+		}).Error
+	})
+}
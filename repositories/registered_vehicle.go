@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RegisteredVehicle is a vehicle known by its plate, shared by all of its wash visits
+type RegisteredVehicle struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Plate        string    `json:"plate" gorm:"uniqueIndex"` // Normalized, see NormalizePlate
+	Make         string    `json:"make"`
+	Model        string    `json:"model"`
+	Color        string    `json:"color"`
+	VehicleClass string    `json:"vehicle_class"`
+	CustomerID   *uint     `json:"customer_id"`                           // Customer who brought it in last
+	Customer     *Customer `json:"customer" gorm:"foreignKey:CustomerID"` // Association
+	LastPackage  string    `json:"last_package"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type RegisteredVehicleRequest struct {
+	Plate        string `form:"plate" binding:"required"`
+	Make         string `form:"make"`
+	Model        string `form:"model"`
+	Color        string `form:"color"`
+	VehicleClass string `form:"vehicle_class"`
+}
+
+var (
+	ErrInvalidPlate   = errors.New("plate is not valid")
+	ErrDuplicatePlate = errors.New("another vehicle is already registered with this plate")
+)
+
+// Indonesian plates are a region code, a number and an optional suffix, e.g. "B 1234 XYZ"
+var platePattern = regexp.MustCompile(`^([A-Z]{1,2})\s*([0-9]{1,4})\s*([A-Z]{0,3})$`)
+
+// NormalizePlate writes a plate the way it is printed, so "b1234xyz" and
+// "B 1234 XYZ" match. Plates in other formats are upper cased with single spaces.
+func NormalizePlate(plate string) string {
+	plate = strings.Join(strings.Fields(strings.ToUpper(plate)), " ")
+	compact := strings.ReplaceAll(plate, " ", "")
+	if match := platePattern.FindStringSubmatch(compact); match != nil {
+		return strings.TrimSpace(match[1] + " " + match[2] + " " + match[3])
+	}
+	return plate
+}
+
+type RegisteredVehicleRepository struct {
+	db *gorm.DB
+}
+
+func NewRegisteredVehicleRepository(db *gorm.DB) *RegisteredVehicleRepository {
+	return &RegisteredVehicleRepository{db: db}
+}
+
+func (r *RegisteredVehicleRepository) FindByID(id uint) (*RegisteredVehicle, error) {
+	var vehicle RegisteredVehicle
+	err := r.db.Preload("Customer").First(&vehicle, id).Error
+	return &vehicle, err
+}
+
+func (r *RegisteredVehicleRepository) FindByPlate(plate string) (*RegisteredVehicle, error) {
+	var vehicle RegisteredVehicle
+	err := r.db.Where("plate = ?", NormalizePlate(plate)).Preload("Customer").First(&vehicle).Error
+	return &vehicle, err
+}
+
+// Search matches registered vehicles by part of their plate, ignoring spaces
+func (r *RegisteredVehicleRepository) Search(query string, limit int) ([]RegisteredVehicle, error) {
+	var vehicles []RegisteredVehicle
+	db := r.db.Order("plate").Limit(limit).Preload("Customer")
+	if query = strings.ToUpper(strings.ReplaceAll(query, " ", "")); query != "" {
+		db = db.Where("REPLACE(plate, ' ', '') LIKE ?", "%"+query+"%")
+	}
+	err := db.Find(&vehicles).Error
+	return vehicles, err
+}
+
+// FindOrCreateByPlate returns the vehicle registered with the plate, registering it on first visit
+func (r *RegisteredVehicleRepository) FindOrCreateByPlate(plate string) (*RegisteredVehicle, error) {
+	normalized := NormalizePlate(plate)
+	if normalized == "" {
+		return nil, ErrInvalidPlate
+	}
+
+	// Concurrent check-ins of a new plate race on the unique plate index
+	vehicle := RegisteredVehicle{Plate: normalized}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&vehicle).Error; err != nil {
+		return nil, err
+	}
+	return r.FindByPlate(normalized)
+}
+
+func (r *RegisteredVehicleRepository) Save(vehicle *RegisteredVehicle) error {
+	return r.db.Omit("Customer").Save(vehicle).Error
+}
+
+func (r *RegisteredVehicleRepository) Update(id uint, input *RegisteredVehicleRequest) error {
+	var existingVehicle RegisteredVehicle
+	if err := r.db.First(&existingVehicle, id).Error; err != nil {
+		return err
+	}
+
+	plate := NormalizePlate(input.Plate)
+	if plate == "" {
+		return ErrInvalidPlate
+	}
+	var duplicates int64
+	if err := r.db.Model(&RegisteredVehicle{}).Where("plate = ? AND id <> ?", plate, id).Count(&duplicates).Error; err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		return ErrDuplicatePlate
+	}
+
+	existingVehicle.Plate = plate
+	existingVehicle.Make = input.Make
+	existingVehicle.Model = input.Model
+	existingVehicle.Color = input.Color
+	existingVehicle.VehicleClass = input.VehicleClass
+
+	return r.db.Save(&existingVehicle).Error
+}
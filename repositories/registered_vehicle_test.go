@@ -0,0 +1,35 @@
+package repositories_test
+
+import (
+	"testing"
+
+	"nevacarwash.com/main/repositories"
+)
+
+func TestNormalizePlate(t *testing.T) {
+	tests := []struct {
+		name  string
+		plate string
+		want  string
+	}{
+		{"compact", "B1234XYZ", "B 1234 XYZ"},
+		{"lower case with spaces", "b 1234 xyz", "B 1234 XYZ"},
+		{"extra spaces", "  B  1234   XYZ ", "B 1234 XYZ"},
+		{"two letter region", "ad1234bc", "AD 1234 BC"},
+		{"short number", "D 1 A", "D 1 A"},
+		{"no suffix", "b1234", "B 1234"},
+		{"three letter region", "rfs 1234 xx", "RFS 1234 XX"},
+		{"five digit number", "B 12345 XYZ", "B 12345 XYZ"},
+		{"four letter suffix", "b 1234 abcd", "B 1234 ABCD"},
+		{"number first", "1234  b", "1234 B"},
+		{"dashes", "B-1234-XYZ", "B-1234-XYZ"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repositories.NormalizePlate(tt.plate); got != tt.want {
+				t.Errorf("NormalizePlate(%q) = %q, want %q", tt.plate, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sequence is a named counter used to hand out vehicle IDs and queue numbers.
+// Counters only ever go up, so numbers are never reused after a delete.
+type Sequence struct {
+	Name  string `gorm:"primaryKey"`
+	Value int64
+}
+
+// VehicleSequence names the counter behind the username-N vehicle IDs of a user
+func VehicleSequence(userID uint) string {
+	return fmt.Sprintf("vehicle:%d", userID)
+}
+
+// QueueSequence names the counter behind the queue numbers of a business day
+func QueueSequence(date time.Time) string {
+	return "queue:" + date.In(BusinessLocation).Format("2006-01-02")
+}
+
+// nextSequence increments the named counter and returns its new value. It
+// must run inside a transaction; the increment locks the row until commit so
+// concurrent callers never see the same value.
+func nextSequence(tx *gorm.DB, name string) (int64, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Sequence{Name: name}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&Sequence{}).Where("name = ?", name).Update("value", gorm.Expr("value + 1")).Error; err != nil {
+		return 0, err
+	}
+	var sequence Sequence
+	if err := tx.Where("name = ?", name).First(&sequence).Error; err != nil {
+		return 0, err
+	}
+	return sequence.Value, nil
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a device a user logged in on. The refresh token the device
+// holds is only stored as a hash, and revoking the session signs it out.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Active reports whether the session can still be used
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RetiredRefreshToken is a refresh token that was swapped for a new one. A
+// retired token coming back means it was copied, so its session is revoked.
+type RetiredRefreshToken struct {
+	ID        uint    `gorm:"primaryKey"`
+	SessionID uint    `gorm:"index"`
+	Session   Session `gorm:"foreignKey:SessionID"`
+	TokenHash string  `gorm:"uniqueIndex"`
+	RetiredAt time.Time
+}
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(session *Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *SessionRepository) FindByID(id uint) (*Session, error) {
+	var session Session
+	err := r.db.First(&session, id).Error
+	return &session, err
+}
+
+func (r *SessionRepository) FindByTokenHash(hash string) (*Session, error) {
+	var session Session
+	err := r.db.Where("token_hash = ?", hash).Preload("User").First(&session).Error
+	return &session, err
+}
+
+// FindActiveByUser returns the devices a user is signed in on, most recently used first
+func (r *SessionRepository) FindActiveByUser(userID uint, now time.Time) ([]Session, error) {
+	var sessions []Session
+	err := r.db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now.UTC()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Rotate swaps the session's refresh token for a new one and records that it
+// was used. It returns false when the old token was swapped out in the meantime.
+func (r *SessionRepository) Rotate(id uint, oldHash, newHash, ip string, now time.Time) (bool, error) {
+	rotated := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Session{}).
+			Where("id = ? AND token_hash = ? AND revoked_at IS NULL", id, oldHash).
+			Updates(map[string]interface{}{
+				"token_hash":   newHash,
+				"last_used_at": now.UTC(),
+				"ip":           ip,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		rotated = true
+		return tx.Create(&RetiredRefreshToken{SessionID: id, TokenHash: oldHash, RetiredAt: now.UTC()}).Error
+	})
+	return rotated && err == nil, err
+}
+
+// FindRetired returns the retired refresh token with the given hash, with its session and user
+func (r *SessionRepository) FindRetired(hash string) (*RetiredRefreshToken, error) {
+	var token RetiredRefreshToken
+	err := r.db.Where("token_hash = ?", hash).Preload("Session.User").First(&token).Error
+	return &token, err
+}
+
+// PruneRetired forgets the retired refresh tokens of sessions that ended, they
+// are refused either way
+func (r *SessionRepository) PruneRetired(now time.Time) error {
+	ended := r.db.Model(&Session{}).Select("id").Where("revoked_at IS NOT NULL OR expires_at <= ?", now.UTC())
+	return r.db.Where("session_id IN (?)", ended).Delete(&RetiredRefreshToken{}).Error
+}
+
+// Revoke signs out one session of the user
+func (r *SessionRepository) Revoke(id, userID uint, now time.Time) error {
+	result := r.db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", now.UTC())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeAll signs the user out everywhere
+func (r *SessionRepository) RevokeAll(userID uint, now time.Time) error {
+	return r.db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now.UTC()).Error
+}
+
+// RevokeAllExcept signs the user out everywhere but the given session
+func (r *SessionRepository) RevokeAllExcept(userID, keepID uint, now time.Time) error {
+	return r.db.Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+		Update("revoked_at", now.UTC()).Error
+}
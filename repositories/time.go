@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"time"
+)
+
+// BusinessLocation is the time zone the car wash operates in. Times are
+// stored in UTC and only converted to this zone to find day boundaries and
+// when they are displayed.
+var BusinessLocation = time.Local
+
+// SetBusinessLocation loads the named IANA time zone, keeping the local zone when name is empty
+func SetBusinessLocation(name string) error {
+	if name == "" {
+		return nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	BusinessLocation = location
+	return nil
+}
+
+// BusinessDay returns the start of the business day t falls on, in UTC
+func BusinessDay(t time.Time) time.Time {
+	local := t.In(BusinessLocation)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, BusinessLocation).UTC()
+}
+
+// Today returns the start of the current business day, in UTC
+func Today() time.Time {
+	return BusinessDay(time.Now())
+}
+
+// ParseBusinessDate parses a YYYY-MM-DD date as a business day
+func ParseBusinessDate(value string) (time.Time, error) {
+	date, err := time.ParseInLocation("2006-01-02", value, BusinessLocation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return date.UTC(), nil
+}
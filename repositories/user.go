@@ -1,24 +1,71 @@
 package repositories
 
 import (
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Roles a user can hold, from most to least privileged
+const (
+	RoleOwner   = "owner"
+	RoleAdmin   = "admin"
+	RoleCashier = "cashier"
+	RoleWasher  = "washer"
+	RoleViewer  = "viewer"
+)
+
+var Roles = []string{RoleOwner, RoleAdmin, RoleCashier, RoleWasher, RoleViewer}
+
+var ErrUsersExist = errors.New("an account already exists")
+
+// ownerSequence is the counter first registrations hold while they check that
+// nobody registered before them
+const ownerSequence = "owner"
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
 	ID        uint      `form:"id" gorm:"primary_key"`
 	Username  string    `form:"username" gorm:"unique"`
 	Password  string    `form:"password"`
-	Admin     bool      `form:"admin"` // New attribute
-	Vehicles  []Vehicle `gorm:"foreignKey:UserID"`          // Association
+	Role      string    `form:"role" gorm:"not null;default:viewer"`
+	Email     string    `form:"email"`
+	Vehicles  []Vehicle `gorm:"foreignKey:UserID"` // Association
 	CreatedAt time.Time
 	UpdatedAt time.Time
+
+	FailedLogins int        `form:"-" gorm:"not null;default:0"` // Wrong passwords since the last successful login
+	LockedUntil  *time.Time `form:"-"`                           // Logins are refused until then
+
+	TOTPSecret    string     `form:"-" json:"-"`                  // Base32 authenticator secret, set while enrolling
+	TOTPEnabledAt *time.Time `form:"-"`                           // Logins need a code once enrollment is confirmed
+	TOTPLastStep  int64      `form:"-" gorm:"not null;default:0"` // Time step of the last accepted code, so it cannot be replayed
+}
+
+// Locked reports whether logins to the account are refused at the given time
+func (u *User) Locked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// TwoFactorEnabled reports whether logins to the account need an authenticator code
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil && u.TOTPSecret != ""
 }
 
 type AuthInput struct {
-	Username string `form:"username" binding:"required"`
-	Password string `form:"password" binding:"required"`
+	Username string `form:"username" json:"username" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required"`
+	Code     string `form:"code" json:"code"` // Authenticator or recovery code, API clients send it with the password
 }
 
 type UserRepository struct {
@@ -33,9 +80,28 @@ func (r *UserRepository) Create(user *User) error {
 	return r.db.Create(user).Error
 }
 
+// CreateFirst creates the user only when there are no users yet. Concurrent
+// first registrations wait on the same counter row, so only one gets through
+// and the others return ErrUsersExist.
+func (r *UserRepository) CreateFirst(user *User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := nextSequence(tx, ownerSequence); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsersExist
+		}
+		return tx.Create(user).Error
+	})
+}
+
 func (r *UserRepository) FindAll() ([]User, error) {
 	var user []User
-	err := r.db.Find(&user).Error
+	err := r.db.Order("username").Find(&user).Error
 	return user, err
 }
 
@@ -45,6 +111,22 @@ func (r *UserRepository) FindByID(id uint) (*User, error) {
 	return &user, err
 }
 
+func (r *UserRepository) FindByUsername(username string) (*User, error) {
+	var user User
+	err := r.db.Where("username = ?", username).First(&user).Error
+	return &user, err
+}
+
+func (r *UserRepository) CountByRole(role string) (int64, error) {
+	var count int64
+	err := r.db.Model(&User{}).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+func (r *UserRepository) UpdateRole(id uint, role string) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Update("role", role).Error
+}
+
 func (r *UserRepository) Update(user *User) error {
 	return r.db.Save(user).Error
 }
@@ -52,3 +134,86 @@ func (r *UserRepository) Update(user *User) error {
 func (r *UserRepository) Delete(id uint) error {
 	return r.db.Delete(&User{}, id).Error
 }
+
+// AddFailedLogin counts one more wrong password for the user and returns the new count
+func (r *UserRepository) AddFailedLogin(id uint) (int, error) {
+	var failures int
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("failed_logins", gorm.Expr("failed_logins + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", id).Pluck("failed_logins", &failures).Error
+	})
+	return failures, err
+}
+
+// LockUntil refuses logins to the user until the given time
+func (r *UserRepository) LockUntil(id uint, until time.Time) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Update("locked_until", until.UTC()).Error
+}
+
+// ClearFailedLogins forgets the user's wrong passwords and lifts any lock
+func (r *UserRepository) ClearFailedLogins(id uint) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+}
+
+func (r *UserRepository) UpdatePassword(id uint, passwordHash string) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Update("password", passwordHash).Error
+}
+
+func (r *UserRepository) UpdateEmail(id uint, email string) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Update("email", email).Error
+}
+
+func (r *UserRepository) Count() (int64, error) {
+	var count int64
+	err := r.db.Model(&User{}).Count(&count).Error
+	return count, err
+}
+
+// SetTOTPSecret starts a new enrollment, any earlier authenticator stops working
+func (r *UserRepository) SetTOTPSecret(id uint, secret string) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	}).Error
+}
+
+// EnableTOTP confirms the enrollment, the code of the given step was used to confirm it
+func (r *UserRepository) EnableTOTP(id uint, step int64, now time.Time) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_enabled_at": now.UTC(),
+		"totp_last_step":  step,
+	}).Error
+}
+
+// UseTOTPStep records the time step of an accepted code. It fails with
+// gorm.ErrRecordNotFound when a code of that step or a later one was already used.
+func (r *UserRepository) UseTOTPStep(id uint, step int64) error {
+	result := r.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", id, step).Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DisableTOTP removes the user's authenticator and recovery codes
+func (r *UserRepository) DisableTOTP(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error
+	})
+}
@@ -1,35 +1,67 @@
 package repositories
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Vehicle struct {
-	ID            string `json:"id"`
-	UserID        uint   `json:"user_id"`           // Foreign key field
-	User          User   `gorm:"foreignKey:UserID"` // Association
-	Queue         int    `json:"queue"`
-	Name          string `json:"name"`
-	Package       string `json:"package"`
-	Plate         string `json:"plate"`
-	Process       string `json:"process"`
-	Contact       string `json:"contact"`
-	Date          string `json:"date"`
-	EnterTime     string `json:"enter_time"`
-	EstimatedTime string `json:"estimated_time"`
-	FinishTime    string `json:"finish_time"`
+	ID                  string             `json:"id"`
+	UserID              uint               `json:"user_id"`                        // Foreign key field
+	User                User               `gorm:"foreignKey:UserID"`              // Association
+	CustomerID          *uint              `json:"customer_id"`                    // Customer the vehicle belongs to, if known
+	Customer            *Customer          `gorm:"foreignKey:CustomerID"`          // Association
+	RegisteredVehicleID *uint              `json:"registered_vehicle_id"`          // Registry entry for the plate
+	RegisteredVehicle   *RegisteredVehicle `gorm:"foreignKey:RegisteredVehicleID"` // Association
+	BayID               *uint              `json:"bay_id"`                         // Bay the vehicle is washed in
+	Bay                 *Bay               `gorm:"foreignKey:BayID"`               // Association
+	Queue               int                `json:"queue" gorm:"uniqueIndex:idx_vehicles_date_queue"`
+	Name                string             `json:"name"`
+	Package             string             `json:"package"`
+	Plate               string             `json:"plate"`
+	Process             string             `json:"process"`
+	Contact             string             `json:"contact"`
+	Date                time.Time          `json:"date" gorm:"uniqueIndex:idx_vehicles_date_queue"` // Start of the business day the vehicle is queued on
+	EnterTime           time.Time          `json:"enter_time"`
+	EstimatedTime       time.Time          `json:"estimated_time"`
+	FinishTime          *time.Time         `json:"finish_time"`
+	TrackingToken       string             `json:"-" gorm:"uniqueIndex"` // Unguessable key of the public status page
 }
 
+var (
+	ErrBayOccupied    = errors.New("bay is already occupied")
+	ErrUnknownPackage = errors.New("unknown package")
+)
+
 type CreateVehicleRequest struct {
-	UID     string `form:"username"`
-	Name    string `form:"name" binding:"required"`
-	Package string `form:"package" binding:"required"`
-	Plate   string `form:"plate" binding:"required"`
-	Contact string `form:"contact"`
-	Process string `form:"process"`
+	UID        string `form:"username" json:"-"`
+	CustomerID uint   `form:"customer_id" json:"customer_id"`
+	Name       string `form:"name" json:"name" binding:"required"`
+	Package    string `form:"package" json:"package" binding:"required"`
+	Plate      string `form:"plate" json:"plate" binding:"required"`
+	Contact    string `form:"contact" json:"contact"`
+	Process    string `form:"process" json:"process"`
+	Make       string `form:"make" json:"make"` // Optional registry details, kept when empty
+	Model      string `form:"model" json:"model"`
+	Color      string `form:"color" json:"color"`
+
+	RegisteredVehicleID uint `form:"-" json:"-"` // Set by the service from the plate
+}
+
+// VehicleFilter narrows down a vehicle search, zero values match everything
+type VehicleFilter struct {
+	Process string
+	Date    *time.Time // Business day the vehicle is queued on
+	Plate   string     // Matches part of the plate, ignoring case and spaces
+	Offset  int
+	Limit   int
 }
 
 type VehicleRepository struct {
@@ -41,75 +73,129 @@ func NewVehicleRepository(db *gorm.DB) *VehicleRepository {
 }
 
 func (r *VehicleRepository) Create(vehicle *CreateVehicleRequest) (string, error) {
-	// Get count of user's vehicles to generate ID
-	var count int64
-	if err := r.db.Model(&Vehicle{}).Where("user_id = ?", vehicle.UID).Count(&count).Error; err != nil {
+	now := time.Now().UTC()
+	today := BusinessDay(now)
+
+	// Get the process time for the vehicle's package from the catalog
+	pkg, err := r.findActivePackage(vehicle.Package)
+	if err != nil {
 		return "", err
 	}
+	processTime := pkg.Duration
 
-	today := time.Now().Format("2006-01-02")
+	var id string
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		// Fetch the user's username from the User model
+		var user User
+		if err := tx.Where("id = ?", vehicle.UID).First(&user).Error; err != nil {
+			return err
+		}
 
-	// Get count of vehicles created today to generate the queue number
-	var countqueue int64
-	if err := r.db.Model(&Vehicle{}).Where("date = ?", today).Count(&countqueue).Error; err != nil {
-		return "", err
-	}
+		// Allocate the ID and today's queue number together so concurrent check-ins never collide
+		count, err := nextSequence(tx, VehicleSequence(user.ID))
+		if err != nil {
+			return err
+		}
+		queue, err := nextSequence(tx, QueueSequence(today))
+		if err != nil {
+			return err
+		}
+		id = fmt.Sprintf("%s-%d", user.Username, count)
+		token, err := NewTrackingToken()
+		if err != nil {
+			return err
+		}
 
-	// Fetch the user's username from the User model
-	var user User
-	if err := r.db.Where("id = ?", vehicle.UID).First(&user).Error; err != nil {
+		// Create a new vehicle instance with ID format (username-vehiclecount)
+		newVehicle := Vehicle{
+			ID:                  id,
+			UserID:              user.ID, // Set the UserID foreign key
+			Name:                vehicle.Name,
+			Package:             vehicle.Package,
+			Plate:               vehicle.Plate,
+			Contact:             vehicle.Contact,
+			CustomerID:          optionalID(vehicle.CustomerID),
+			RegisteredVehicleID: optionalID(vehicle.RegisteredVehicleID),
+			Process:             "Waiting",
+			Date:                today,
+			EnterTime:           now,
+			Queue:               int(queue), // Set the queue number
+			EstimatedTime:       now.Add(time.Duration(processTime) * time.Minute),
+			TrackingToken:       token,
+		}
+		return tx.Create(&newVehicle).Error
+	})
+	if err != nil {
 		return "", err
 	}
-	id := fmt.Sprintf("%s-%d", user.Username, count+1)
+	return id, nil
+}
 
-	processTimes := map[string]int{
-		"Motor Besar":     30,
-		"Mobil Besar":     50,
-		"Mobil":           40,
-		"Motor":           25,
-		"Cuci Luar Mobil": 40,
+// FindByProcess returns today's vehicles in the given process. Vehicles that
+// are still waiting or being washed are returned whatever day they came in.
+func (r *VehicleRepository) FindByProcess(process string) ([]Vehicle, error) {
+	var vehicles []Vehicle
+	query := r.db.Where("process = ?", process)
+	if process != "Waiting" && process != "Washing" {
+		query = query.Where("date = ?", Today())
 	}
+	err := query.Order("date, queue").Preload("User").Preload("Bay").Find(&vehicles).Error
+	return vehicles, err
+}
 
-	// Get the process time for the vehicle's package
-	processTime, exists := processTimes[vehicle.Package]
-	if !exists {
-		return "", fmt.Errorf("unknown package: %s", vehicle.Package)
+// FindActive returns every vehicle that is still waiting or being washed, oldest first
+func (r *VehicleRepository) FindActive() ([]Vehicle, error) {
+	var vehicles []Vehicle
+	err := r.db.Where("process IN ?", []string{"Waiting", "Washing"}).Order("date, queue").Preload("User").Preload("Bay").Find(&vehicles).Error
+	return vehicles, err
+}
+
+// CarryForward moves an unfinished vehicle to the given date with the next free queue number
+func (r *VehicleRepository) CarryForward(id string, date time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		queue, err := nextSequence(tx, QueueSequence(date))
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Vehicle{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"date":  date,
+			"queue": queue,
+		}).Error
+	})
+}
+
+func (r *VehicleRepository) UpdateEstimatedTime(id string, estimatedTime time.Time) error {
+	return r.db.Model(&Vehicle{}).Where("id = ?", id).Update("estimated_time", estimatedTime.UTC()).Error
+}
+
+// Search returns one page of vehicles matching the filter, newest first, with the total match count
+func (r *VehicleRepository) Search(filter VehicleFilter) ([]Vehicle, int64, error) {
+	query := r.db.Model(&Vehicle{})
+	if filter.Process != "" {
+		query = query.Where("process = ?", filter.Process)
+	}
+	if filter.Date != nil {
+		query = query.Where("date = ?", filter.Date.UTC())
+	}
+	if filter.Plate != "" {
+		plate := strings.ToUpper(strings.ReplaceAll(filter.Plate, " ", ""))
+		query = query.Where("UPPER(REPLACE(plate, ' ', '')) LIKE ?", "%"+plate+"%")
 	}
 
-	// Calculate the estimated time
-	enterTime, err := time.Parse("15:04", time.Now().Format("15:04"))
-	if err != nil {
-		return "", err
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
 	}
-	estimatedtime := enterTime.Add(time.Duration(processTime) * time.Minute).Format("3:04 PM")
-	var finishtime string
-	if vehicle.Process == "Finish" {
-		finishtime = time.Now().Format("3:04 PM")
-	}
-	// Create a new vehicle instance with ID format (username-vehiclecount)
-	newVehicle := Vehicle{
-		ID:      id,
-		UserID:  user.ID, // Set the UserID foreign key
-		Name:    vehicle.Name,
-		Package: vehicle.Package,
-		Plate:   vehicle.Plate,
-		Contact: vehicle.Contact,
-		Process: "Waiting",
-		// Date:    "2025-01-20",
-		Date:          time.Now().Format("2006-01-02"),
-		EnterTime:     time.Now().Format("3:04 PM"),
-		Queue:         int(countqueue + 1), // Set the queue number
-		EstimatedTime: estimatedtime,
-		FinishTime:    finishtime,
-	}
-	return id, r.db.Create(&newVehicle).Error
-}
 
-func (r *VehicleRepository) FindByProcess(process string) ([]Vehicle, error) {
 	var vehicles []Vehicle
-	today := time.Now().Format("2006-01-02") //"2025-01-20"
-	err := r.db.Where("process = ? AND date = ?", process, today).Preload("User").Find(&vehicles).Error
-	return vehicles, err
+	err := query.
+		Order("date DESC, queue DESC").
+		Offset(filter.Offset).
+		Limit(filter.Limit).
+		Preload("User").
+		Preload("Bay").
+		Find(&vehicles).Error
+	return vehicles, total, err
 }
 
 func (r *VehicleRepository) FindByUsername(username string) ([]Vehicle, error) {
@@ -117,6 +203,7 @@ func (r *VehicleRepository) FindByUsername(username string) ([]Vehicle, error) {
 	err := r.db.
 		Joins("JOIN users ON users.id = vehicles.user_id").
 		Where("users.username = ?", username).
+		Order("vehicles.date, vehicles.queue").
 		Preload("User").
 		Find(&vehicles).Error
 	return vehicles, err
@@ -124,9 +211,38 @@ func (r *VehicleRepository) FindByUsername(username string) ([]Vehicle, error) {
 
 func (r *VehicleRepository) FindByID(id string) (*Vehicle, error) {
 	var vehicle Vehicle
-	err := r.db.Where("id = ?", id).Preload("User").First(&vehicle).Error
+	err := r.db.Where("id = ?", id).Preload("User").Preload("Bay").Preload("Customer").Preload("RegisteredVehicle").First(&vehicle).Error
 	return &vehicle, err
 }
+
+// FindByTrackingToken returns the vehicle a customer follows on the public status page
+func (r *VehicleRepository) FindByTrackingToken(token string) (*Vehicle, error) {
+	var vehicle Vehicle
+	err := r.db.Where("tracking_token = ?", token).First(&vehicle).Error
+	return &vehicle, err
+}
+
+// FindByCustomer returns the wash history of a customer, newest first
+func (r *VehicleRepository) FindByCustomer(customerID uint) ([]Vehicle, error) {
+	var vehicles []Vehicle
+	err := r.db.Where("customer_id = ?", customerID).Order("date DESC, queue DESC").Preload("User").Find(&vehicles).Error
+	return vehicles, err
+}
+
+// FindByRegisteredVehicle returns every visit of a registered vehicle, newest first
+func (r *VehicleRepository) FindByRegisteredVehicle(registeredVehicleID uint) ([]Vehicle, error) {
+	var vehicles []Vehicle
+	err := r.db.Where("registered_vehicle_id = ?", registeredVehicleID).Order("date DESC, queue DESC").Preload("User").Preload("Customer").Find(&vehicles).Error
+	return vehicles, err
+}
+
+// CountByRegisteredVehicle returns how many times a registered vehicle has come in
+func (r *VehicleRepository) CountByRegisteredVehicle(registeredVehicleID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&Vehicle{}).Where("registered_vehicle_id = ?", registeredVehicleID).Count(&count).Error
+	return count, err
+}
+
 func (r *VehicleRepository) Update(id string, vehicle *CreateVehicleRequest) error {
 	var existingVehicle Vehicle
 	if err := r.db.Where("id = ?", id).First(&existingVehicle).Error; err != nil {
@@ -134,7 +250,17 @@ func (r *VehicleRepository) Update(id string, vehicle *CreateVehicleRequest) err
 	}
 
 	if existingVehicle.Process != "Finish" && vehicle.Process == "Finish" {
-		existingVehicle.FinishTime = time.Now().Format("3:04 PM")
+		finishTime := time.Now().UTC()
+		existingVehicle.FinishTime = &finishTime
+	}
+
+	// Re-estimate when the package changes
+	if existingVehicle.Package != vehicle.Package {
+		pkg, err := r.findActivePackage(vehicle.Package)
+		if err != nil {
+			return err
+		}
+		existingVehicle.EstimatedTime = existingVehicle.EnterTime.Add(time.Duration(pkg.Duration) * time.Minute)
 	}
 
 	// Update the fields of the existing vehicle
@@ -143,12 +269,31 @@ func (r *VehicleRepository) Update(id string, vehicle *CreateVehicleRequest) err
 	existingVehicle.Process = vehicle.Process
 	existingVehicle.Plate = vehicle.Plate
 	existingVehicle.Contact = vehicle.Contact
+	if vehicle.CustomerID != 0 {
+		existingVehicle.CustomerID = optionalID(vehicle.CustomerID)
+	}
+	if vehicle.RegisteredVehicleID != 0 {
+		existingVehicle.RegisteredVehicleID = optionalID(vehicle.RegisteredVehicleID)
+	}
 
 	return r.db.Save(&existingVehicle).Error
 }
 
+// Delete removes the vehicle together with its history, messages and unpaid
+// invoice. A paid invoice returns ErrInvoicePaid and nothing is removed.
 func (r *VehicleRepository) Delete(id string) error {
-	return r.db.Where("id = ?", id).Delete(&Vehicle{}).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteUnpaidInvoice(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Where("vehicle_id = ?", id).Delete(&VehicleEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("vehicle_id = ?", id).Delete(&Notification{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&Vehicle{}).Error
+	})
 }
 
 func (r *VehicleRepository) UpdateProcess(id string, process string) error {
@@ -157,10 +302,60 @@ func (r *VehicleRepository) UpdateProcess(id string, process string) error {
 		return err
 	}
 	if existingVehicle.Process != "Finish" && process == "Finish" {
-		existingVehicle.FinishTime = time.Now().Format("3:04 PM")
+		finishTime := time.Now().UTC()
+		existingVehicle.FinishTime = &finishTime
 	}
 
 	existingVehicle.Process = process
 
 	return r.db.Save(&existingVehicle).Error
 }
+
+// StartWashing moves a vehicle into the given bay, failing if another vehicle is already washed there
+func (r *VehicleRepository) StartWashing(id string, bayID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the bay until commit so two starts cannot both find it free.
+		// SQLite ignores FOR UPDATE, its transactions take the write lock as they begin.
+		var bay Bay
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&bay, bayID).Error; err != nil {
+			return err
+		}
+		var occupied int64
+		if err := tx.Model(&Vehicle{}).Where("bay_id = ? AND process = ? AND id <> ?", bayID, "Washing", id).Count(&occupied).Error; err != nil {
+			return err
+		}
+		if occupied > 0 {
+			return ErrBayOccupied
+		}
+		return tx.Model(&Vehicle{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"process": "Washing",
+			"bay_id":  bayID,
+		}).Error
+	})
+}
+
+// NewTrackingToken returns a random token for the public status page of a visit
+func NewTrackingToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// optionalID turns an optional reference of a request into a nullable column value
+func optionalID(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func (r *VehicleRepository) findActivePackage(name string) (*Package, error) {
+	var pkg Package
+	err := r.db.Where("name = ? AND active = ?", name, true).First(&pkg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPackage, name)
+	}
+	return &pkg, err
+}
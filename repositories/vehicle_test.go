@@ -0,0 +1,103 @@
+package repositories_test
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"nevacarwash.com/main/database"
+	"nevacarwash.com/main/repositories"
+)
+
+// openTestDB migrates a fresh SQLite database, opened the way the server opens it
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("DB", "sqlite")
+	t.Setenv("DATABASE_PATH", filepath.Join(t.TempDir(), "test.db"))
+	if err := database.InitializeDatabaseLayer(); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.MigrateUp(false, io.Discard); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	db := database.GetDB()
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestCreateConcurrentCheckInsGetUniqueIDsAndQueues(t *testing.T) {
+	db := openTestDB(t)
+	users := repositories.NewUserRepository(db)
+	vehicles := repositories.NewVehicleRepository(db)
+
+	// Two cashiers checking in at once share the day's queue but each have their own IDs
+	var uids []string
+	for _, name := range []string{"ana", "budi"} {
+		user := &repositories.User{Username: name}
+		if err := users.Create(user); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		uids = append(uids, strconv.FormatUint(uint64(user.ID), 10))
+	}
+
+	const checkIns = 40
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	ids := make([]string, checkIns)
+	errs := make([]error, checkIns)
+	for i := 0; i < checkIns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			ids[i], errs[i] = vehicles.Create(&repositories.CreateVehicleRequest{
+				UID:     uids[i%len(uids)],
+				Name:    fmt.Sprintf("Customer %d", i),
+				Package: "Mobil",
+				Plate:   fmt.Sprintf("B %d XYZ", 1000+i),
+			})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, id := range ids {
+		if errs[i] != nil {
+			t.Fatalf("check-in %d failed: %v", i, errs[i])
+		}
+		if seen[id] {
+			t.Errorf("vehicle ID %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+
+	var stored []repositories.Vehicle
+	if err := db.Find(&stored).Error; err != nil {
+		t.Fatalf("load vehicles: %v", err)
+	}
+	if len(stored) != checkIns {
+		t.Fatalf("stored %d vehicles, want %d", len(stored), checkIns)
+	}
+	type slot struct {
+		date  time.Time
+		queue int
+	}
+	queues := make(map[slot]string)
+	for _, v := range stored {
+		key := slot{v.Date.UTC(), v.Queue}
+		if other, ok := queues[key]; ok {
+			t.Errorf("queue %d on %s given to both %s and %s", v.Queue, v.Date.Format("2006-01-02"), other, v.ID)
+		}
+		queues[key] = v.ID
+	}
+}
@@ -0,0 +1,169 @@
+package repositories
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending" // A retry claimed it and is posting
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	ErrInvalidWebhookURL   = errors.New("webhook URL must start with http:// or https://")
+	ErrUnknownWebhookEvent = errors.New("unknown webhook event")
+)
+
+// Webhook is an outside system told about vehicle events as they happen
+type Webhook struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`      // Signs each payload so the receiver can check it came from us
+	Events    string    `json:"events"` // Comma separated event types
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EventList returns the event types the webhook subscribes to
+func (w *Webhook) EventList() []string {
+	if w.Events == "" {
+		return nil
+	}
+	return strings.Split(w.Events, ",")
+}
+
+// Subscribes reports whether the webhook wants the event type
+func (w *Webhook) Subscribes(event string) bool {
+	for _, subscribed := range w.EventList() {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookRequest struct {
+	URL    string   `form:"url" binding:"required"`
+	Secret string   `form:"secret"` // Generated when left empty
+	Events []string `form:"events" binding:"required"`
+	Active bool     `form:"active"`
+}
+
+// WebhookDelivery is one payload sent to a webhook and how sending it went
+type WebhookDelivery struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	WebhookID      uint       `json:"webhook_id" gorm:"index"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status" gorm:"index"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status"` // HTTP status of the last attempt, 0 when there was no answer
+	Error          string     `json:"error"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"` // Also set while pending or sending, in case the attempt never finishes
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(webhook *Webhook) error {
+	return r.db.Create(webhook).Error
+}
+
+func (r *WebhookRepository) FindAll() ([]Webhook, error) {
+	var webhooks []Webhook
+	err := r.db.Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r *WebhookRepository) FindActive() ([]Webhook, error) {
+	var webhooks []Webhook
+	err := r.db.Where("active = ?", true).Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r *WebhookRepository) FindByID(id uint) (*Webhook, error) {
+	var webhook Webhook
+	err := r.db.First(&webhook, id).Error
+	return &webhook, err
+}
+
+func (r *WebhookRepository) Save(webhook *Webhook) error {
+	return r.db.Save(webhook).Error
+}
+
+// Delete removes the webhook together with its delivery log
+func (r *WebhookRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&Webhook{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *WebhookRepository) CreateDelivery(delivery *WebhookDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+func (r *WebhookRepository) FindDelivery(id uint) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := r.db.First(&delivery, id).Error
+	return &delivery, err
+}
+
+// FindDeliveries returns the latest deliveries to a webhook, newest first
+func (r *WebhookRepository) FindDeliveries(webhookID uint, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := r.db.Where("webhook_id = ?", webhookID).Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// FindDue returns undelivered deliveries whose next attempt is due. Pending
+// and sending ones are only due when their attempt never finished.
+func (r *WebhookRepository) FindDue(now time.Time) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := r.db.
+		Where("status <> ? AND next_attempt_at IS NOT NULL AND next_attempt_at <= ?", DeliveryDelivered, now.UTC()).
+		Order("id").
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ClaimDelivery moves a due delivery to sending and holds it until leaseUntil,
+// when it is due again should the attempt never finish. It reports false when
+// another server claimed it first, only the winner may post it.
+func (r *WebhookRepository) ClaimDelivery(id uint, now, leaseUntil time.Time) (bool, error) {
+	result := r.db.Model(&WebhookDelivery{}).
+		Where("id = ? AND status <> ? AND next_attempt_at IS NOT NULL AND next_attempt_at <= ?", id, DeliveryDelivered, now.UTC()).
+		Updates(map[string]interface{}{
+			"status":          DeliverySending,
+			"next_attempt_at": leaseUntil.UTC(),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *WebhookRepository) SaveDelivery(delivery *WebhookDelivery) error {
+	return r.db.Save(delivery).Error
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"nevacarwash.com/main/repositories"
+)
+
+const (
+	// InviteTTL is how long an invite link can be used to register
+	InviteTTL = 7 * 24 * time.Hour
+	// PasswordResetTTL is how long a reset link can be used to pick a new password
+	PasswordResetTTL = time.Hour
+)
+
+var (
+	ErrInviteRequired   = errors.New("registration is by invite only, ask a manager for an invite link")
+	ErrInviteInvalid    = errors.New("this invite link is invalid, used or expired, ask a manager for a new one")
+	ErrResetInvalid     = errors.New("this reset link is invalid, used or expired, ask a manager for a new one")
+	ErrUsernameTaken    = errors.New("username already used")
+	ErrWrongPassword    = errors.New("current password is wrong")
+	ErrPasswordMismatch = errors.New("the new passwords do not match")
+	ErrInvalidEmail     = errors.New("enter a valid email address")
+)
+
+// RegisterInput is what someone fills in to create their account
+type RegisterInput struct {
+	Username string `form:"username" binding:"required"`
+	Password string `form:"password" binding:"required"`
+	Confirm  string `form:"confirm" binding:"required"`
+}
+
+// PasswordInput is a new password typed twice
+type PasswordInput struct {
+	Current  string `form:"current"`
+	Password string `form:"password" binding:"required"`
+	Confirm  string `form:"confirm" binding:"required"`
+}
+
+type AccountService struct {
+	userRepo   *repositories.UserRepository
+	inviteRepo *repositories.InviteRepository
+	resetRepo  *repositories.PasswordResetRepository
+	sessions   *SessionService
+	mailer     Mailer
+}
+
+func NewAccountService(userRepo *repositories.UserRepository, inviteRepo *repositories.InviteRepository, resetRepo *repositories.PasswordResetRepository, sessions *SessionService, mailer Mailer) *AccountService {
+	return &AccountService{userRepo: userRepo, inviteRepo: inviteRepo, resetRepo: resetRepo, sessions: sessions, mailer: mailer}
+}
+
+// NeedsOwner reports whether nobody has registered yet, the first account
+// owns the shop and needs no invite
+func (s *AccountService) NeedsOwner() (bool, error) {
+	if s.userRepo == nil {
+		return false, errors.New("repository is nil")
+	}
+	count, err := s.userRepo.Count()
+	return count == 0, err
+}
+
+// RegisterOwner creates the first account, once nobody has registered yet
+func (s *AccountService) RegisterOwner(input RegisterInput) error {
+	needsOwner, err := s.NeedsOwner()
+	if err != nil {
+		return err
+	}
+	if !needsOwner {
+		return ErrInviteInvalid
+	}
+	user, err := newUser(input, repositories.RoleOwner, "")
+	if err != nil {
+		return err
+	}
+	// Checked again while creating, someone may have registered in between
+	if err := s.userRepo.CreateFirst(user); errors.Is(err, repositories.ErrUsersExist) {
+		return ErrInviteInvalid
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateInvite emails a registration link for the role to the address. Only
+// owners may invite owners. The link, starting with baseURL, is returned too
+// so it can be passed on by hand when the email does not arrive.
+func (s *AccountService) CreateInvite(baseURL string, actorID uint, actorRole string, input repositories.InviteRequest) (string, error) {
+	if s.inviteRepo == nil {
+		return "", errors.New("repository is nil")
+	}
+	email, err := parseEmail(input.Email)
+	if err != nil {
+		return "", err
+	}
+	if !repositories.ValidRole(input.Role) {
+		return "", ErrInvalidRole
+	}
+	if input.Role == repositories.RoleOwner && actorRole != repositories.RoleOwner {
+		return "", ErrRoleForbidden
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	invite := &repositories.Invite{
+		Email:       email,
+		Role:        input.Role,
+		TokenHash:   hashToken(token),
+		CreatedByID: actorID,
+		ExpiresAt:   time.Now().UTC().Add(InviteTTL),
+	}
+	if err := s.inviteRepo.Create(invite); err != nil {
+		return "", err
+	}
+
+	link := baseURL + "/register?invite=" + token
+	body := fmt.Sprintf("You have been invited to join %s as %s.\n\nCreate your account here, the link works for %d days:\n%s\n",
+		BusinessName, input.Role, int(InviteTTL.Hours()/24), link)
+	s.mail(email, "Your "+BusinessName+" staff account", body)
+	return link, nil
+}
+
+// GetInvites lists the invites nobody has used yet
+func (s *AccountService) GetInvites() ([]repositories.Invite, error) {
+	if s.inviteRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.inviteRepo.FindPending(time.Now())
+}
+
+// RevokeInvite stops an unused invite link from working
+func (s *AccountService) RevokeInvite(id uint) error {
+	if s.inviteRepo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.inviteRepo.Delete(id)
+}
+
+// GetInvite returns the invite an invite link belongs to, if it can still be used
+func (s *AccountService) GetInvite(token string) (*repositories.Invite, error) {
+	if s.inviteRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	invite, err := s.inviteRepo.FindByTokenHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !invite.Usable(time.Now())) {
+		return nil, ErrInviteInvalid
+	}
+	return invite, err
+}
+
+// Register creates the account an invite was sent for
+func (s *AccountService) Register(token string, input RegisterInput) error {
+	invite, err := s.GetInvite(token)
+	if err != nil {
+		return err
+	}
+	input.Username = strings.TrimSpace(input.Username)
+	if _, err := s.userRepo.FindByUsername(input.Username); err == nil {
+		return ErrUsernameTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	user, err := newUser(input, invite.Role, invite.Email)
+	if err != nil {
+		return err
+	}
+	if err := s.inviteRepo.Use(invite, user, time.Now()); errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInviteInvalid
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangePassword sets a new password for a signed in user, who proves it is
+// them with their current one. Their other devices are signed out.
+func (s *AccountService) ChangePassword(userID, sessionID uint, input PasswordInput) error {
+	if s.userRepo == nil {
+		return errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Current)) != nil {
+		return ErrWrongPassword
+	}
+	hash, err := newPasswordHash(input.Password, input.Confirm, user.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(user.ID, hash); err != nil {
+		return err
+	}
+	return s.sessions.RevokeOthers(user.ID, sessionID)
+}
+
+// UpdateEmail changes where the user's account emails are sent
+func (s *AccountService) UpdateEmail(userID uint, email string) error {
+	if s.userRepo == nil {
+		return errors.New("repository is nil")
+	}
+	if strings.TrimSpace(email) == "" {
+		return s.userRepo.UpdateEmail(userID, "")
+	}
+	address, err := parseEmail(email)
+	if err != nil {
+		return err
+	}
+	return s.userRepo.UpdateEmail(userID, address)
+}
+
+// CreatePasswordReset emails the user a link to pick a new password. The
+// link is returned too, for users without an email address. Only owners may
+// reset the password of an owner.
+func (s *AccountService) CreatePasswordReset(baseURL string, actorID uint, actorRole string, userID uint) (string, *repositories.User, error) {
+	if s.resetRepo == nil {
+		return "", nil, errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.Role == repositories.RoleOwner && actorRole != repositories.RoleOwner {
+		return "", nil, ErrRoleForbidden
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	reset := &repositories.PasswordReset{
+		UserID:      user.ID,
+		TokenHash:   hashToken(token),
+		CreatedByID: actorID,
+		ExpiresAt:   time.Now().UTC().Add(PasswordResetTTL),
+	}
+	if err := s.resetRepo.Create(reset); err != nil {
+		return "", nil, err
+	}
+
+	link := baseURL + "/reset/" + token
+	if user.Email != "" {
+		body := fmt.Sprintf("Hi %s,\n\nA manager at %s started a password reset for your account. Pick a new password here, the link works for %d minutes:\n%s\n\nIf you did not expect this, let your manager know.\n",
+			user.Username, BusinessName, int(PasswordResetTTL.Minutes()), link)
+		s.mail(user.Email, "Reset your "+BusinessName+" password", body)
+	}
+	return link, user, nil
+}
+
+// GetPasswordReset returns the reset a link belongs to, if it can still be used
+func (s *AccountService) GetPasswordReset(token string) (*repositories.PasswordReset, error) {
+	if s.resetRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	reset, err := s.resetRepo.FindByTokenHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !reset.Usable(time.Now())) {
+		return nil, ErrResetInvalid
+	}
+	return reset, err
+}
+
+// ResetPassword sets the new password picked through a reset link, lifts any
+// login lock and signs the user out everywhere
+func (s *AccountService) ResetPassword(token string, input PasswordInput) error {
+	reset, err := s.GetPasswordReset(token)
+	if err != nil {
+		return err
+	}
+	hash, err := newPasswordHash(input.Password, input.Confirm, reset.User.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.resetRepo.Use(reset, hash, time.Now()); errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrResetInvalid
+	} else if err != nil {
+		return err
+	}
+	return s.sessions.RevokeAll(reset.UserID)
+}
+
+// mail sends in the background, a slow or missing mail server must not hold
+// up the page. The link is shown to the manager either way.
+func (s *AccountService) mail(to, subject, body string) {
+	if s.mailer == nil {
+		log.Printf("No mailer configured, not emailing %s", to)
+		return
+	}
+	go func() {
+		if err := s.mailer.Send(to, subject, body); err != nil {
+			log.Printf("Failed to email %s: %v", to, err)
+		}
+	}()
+}
+
+func newUser(input RegisterInput, role, email string) (*repositories.User, error) {
+	username := strings.TrimSpace(input.Username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	hash, err := newPasswordHash(input.Password, input.Confirm, username)
+	if err != nil {
+		return nil, err
+	}
+	return &repositories.User{Username: username, Password: hash, Role: role, Email: email}, nil
+}
+
+// newPasswordHash checks a new password typed twice and hashes it
+func newPasswordHash(password, confirm, username string) (string, error) {
+	if password != confirm {
+		return "", ErrPasswordMismatch
+	}
+	if err := ValidatePassword(password, username); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func parseEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+	return address.Address, nil
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"nevacarwash.com/main/repositories"
+)
+
+func TestRegisterOwnerConcurrentOnlyOneSucceeds(t *testing.T) {
+	db := openTestDB(t)
+	users := repositories.NewUserRepository(db)
+	service := NewAccountService(users, nil, nil, nil, nil)
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = service.RegisterOwner(RegisterInput{
+				Username: fmt.Sprintf("owner%d", i),
+				Password: "Kuda-Lari#Cepat42",
+				Confirm:  "Kuda-Lari#Cepat42",
+			})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrInviteInvalid):
+			t.Errorf("registration %d failed: %v", i, err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d registrations succeeded, want 1", created)
+	}
+	count, err := users.CountByRole(repositories.RoleOwner)
+	if err != nil {
+		t.Fatalf("count owners: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("%d owners in the database, want 1", count)
+	}
+}
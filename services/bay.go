@@ -0,0 +1,64 @@
+package services
+
+import (
+	"errors"
+
+	"nevacarwash.com/main/repositories"
+)
+
+type BayService struct {
+	repo *repositories.BayRepository
+}
+
+func NewBayService(repo *repositories.BayRepository) *BayService {
+	return &BayService{repo: repo}
+}
+
+func (s *BayService) CreateBay(input *repositories.BayRequest) (*repositories.Bay, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.Create(input)
+}
+
+func (s *BayService) GetBays() ([]repositories.Bay, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindAll()
+}
+
+func (s *BayService) GetActiveBays() ([]repositories.Bay, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindActive()
+}
+
+func (s *BayService) GetFreeBays() ([]repositories.Bay, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindFree()
+}
+
+func (s *BayService) GetBayByID(id uint) (*repositories.Bay, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByID(id)
+}
+
+func (s *BayService) UpdateBay(id uint, input repositories.BayRequest) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Update(id, &input)
+}
+
+func (s *BayService) DeleteBay(id uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Delete(id)
+}
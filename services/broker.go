@@ -0,0 +1,58 @@
+package services
+
+import "sync"
+
+const (
+	ChangeCreated      = "vehicle.created"
+	ChangeUpdated      = "vehicle.updated"
+	ChangeTransitioned = "vehicle.transitioned"
+	ChangeDeleted      = "vehicle.deleted"
+)
+
+// VehicleChange tells listeners that a vehicle on the queue changed
+type VehicleChange struct {
+	Type      string `json:"type"`
+	VehicleID string `json:"vehicle_id,omitempty"`
+	Process   string `json:"process,omitempty"`
+}
+
+// Broker fans vehicle changes out to every open live view, like the queue
+// board. It lives in memory, so listeners only see changes made by this server.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan VehicleChange]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[chan VehicleChange]struct{}{}}
+}
+
+// Subscribe returns a channel receiving every change from now on and a
+// function to call when the listener goes away
+func (b *Broker) Subscribe() (<-chan VehicleChange, func()) {
+	ch := make(chan VehicleChange, 16)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends the change to every subscriber. A subscriber that falls
+// behind misses changes rather than holding up the request publishing them.
+func (b *Broker) Publish(change VehicleChange) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// customerSearchLimit caps the suggestions returned while typing
+const customerSearchLimit = 10
+
+type CustomerService struct {
+	repo        *repositories.CustomerRepository
+	vehicleRepo *repositories.VehicleRepository
+}
+
+func NewCustomerService(repo *repositories.CustomerRepository, vehicleRepo *repositories.VehicleRepository) *CustomerService {
+	return &CustomerService{repo: repo, vehicleRepo: vehicleRepo}
+}
+
+func (s *CustomerService) GetCustomerByID(id uint) (*repositories.Customer, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByID(id)
+}
+
+func (s *CustomerService) SearchCustomers(query string) ([]repositories.Customer, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.Search(query, customerSearchLimit)
+}
+
+// GetCustomers lists customers for the customers page, optionally filtered by name or phone
+func (s *CustomerService) GetCustomers(query string) ([]repositories.Customer, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.Search(query, 100)
+}
+
+// GetWashHistory lists every vehicle brought in by the customer, newest first
+func (s *CustomerService) GetWashHistory(id uint) ([]repositories.Vehicle, error) {
+	if s.vehicleRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.vehicleRepo.FindByCustomer(id)
+}
+
+func (s *CustomerService) UpdateCustomer(id uint, input repositories.CustomerRequest) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Update(id, &input)
+}
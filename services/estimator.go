@@ -0,0 +1,148 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// defaultWashMinutes is used for vehicles whose package is no longer in the catalog
+const defaultWashMinutes = 30
+
+// Estimator predicts when each active vehicle will be done, taking the
+// vehicles ahead of it and the active wash bays into account.
+type Estimator struct {
+	packageRepo *repositories.PackageRepository
+	eventRepo   *repositories.VehicleEventRepository
+	bayRepo     *repositories.BayRepository
+}
+
+func NewEstimator(packageRepo *repositories.PackageRepository, eventRepo *repositories.VehicleEventRepository, bayRepo *repositories.BayRepository) *Estimator {
+	return &Estimator{packageRepo: packageRepo, eventRepo: eventRepo, bayRepo: bayRepo}
+}
+
+// bayState tracks when a bay becomes free during the simulation
+type bayState struct {
+	bay  repositories.Bay
+	free time.Time
+}
+
+// Estimate returns the expected finish time keyed by vehicle ID. Vehicles
+// being washed keep their bay until their package duration has elapsed,
+// waiting vehicles are then assigned in queue order to the first free bay
+// that supports their vehicle class.
+func (e *Estimator) Estimate(vehicles []repositories.Vehicle, now time.Time) (map[string]time.Time, error) {
+	packages, err := e.packageRepo.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	durations := make(map[string]time.Duration, len(packages))
+	classes := make(map[string]string, len(packages))
+	for _, pkg := range packages {
+		durations[pkg.Name] = time.Duration(pkg.Duration) * time.Minute
+		classes[pkg.Name] = pkg.VehicleClass
+	}
+	duration := func(vehicle repositories.Vehicle) time.Duration {
+		if d, ok := durations[vehicle.Package]; ok {
+			return d
+		}
+		return defaultWashMinutes * time.Minute
+	}
+
+	activeBays, err := e.bayRepo.FindActive()
+	if err != nil {
+		return nil, err
+	}
+	// Every bay starts free right now, with a single virtual bay if none are configured
+	bays := make([]*bayState, 0, len(activeBays))
+	for _, bay := range activeBays {
+		bays = append(bays, &bayState{bay: bay, free: now})
+	}
+	if len(bays) == 0 {
+		bays = append(bays, &bayState{free: now})
+	}
+
+	var washing, waiting []repositories.Vehicle
+	for _, vehicle := range vehicles {
+		switch vehicle.Process {
+		case ProcessWashing:
+			washing = append(washing, vehicle)
+		case ProcessWaiting:
+			waiting = append(waiting, vehicle)
+		}
+	}
+	// Vehicles carried over from earlier days go first
+	sort.SliceStable(waiting, func(i, j int) bool {
+		if !waiting[i].Date.Equal(waiting[j].Date) {
+			return waiting[i].Date.Before(waiting[j].Date)
+		}
+		return waiting[i].Queue < waiting[j].Queue
+	})
+
+	estimates := make(map[string]time.Time, len(washing)+len(waiting))
+
+	for _, vehicle := range washing {
+		started := now
+		if event, err := e.eventRepo.FindLastTransition(vehicle.ID, ProcessWashing); err == nil {
+			started = event.CreatedAt
+		}
+		bay := assignedBay(bays, vehicle.BayID)
+		if bay == nil {
+			bay = earliestBay(bays, classes[vehicle.Package])
+		}
+		if bay.free.After(started) {
+			started = bay.free
+		}
+		finish := started.Add(duration(vehicle))
+		if finish.Before(now) {
+			finish = now
+		}
+		bay.free = finish
+		estimates[vehicle.ID] = finish
+	}
+
+	for _, vehicle := range waiting {
+		bay := earliestBay(bays, classes[vehicle.Package])
+		finish := bay.free.Add(duration(vehicle))
+		bay.free = finish
+		estimates[vehicle.ID] = finish
+	}
+
+	return estimates, nil
+}
+
+func assignedBay(bays []*bayState, bayID *uint) *bayState {
+	if bayID == nil {
+		return nil
+	}
+	for _, bay := range bays {
+		if bay.bay.ID == *bayID {
+			return bay
+		}
+	}
+	return nil
+}
+
+// earliestBay picks the bay that frees up first among those supporting the
+// class, falling back to every bay when none of them do.
+func earliestBay(bays []*bayState, class string) *bayState {
+	var earliest *bayState
+	for _, bay := range bays {
+		if !bay.bay.Supports(class) {
+			continue
+		}
+		if earliest == nil || bay.free.Before(earliest.free) {
+			earliest = bay
+		}
+	}
+	if earliest != nil {
+		return earliest
+	}
+	for _, bay := range bays {
+		if earliest == nil || bay.free.Before(earliest.free) {
+			earliest = bay
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"nevacarwash.com/main/repositories"
+)
+
+// TaxPercent is applied to new invoices after the discount, 0 disables tax
+var TaxPercent float64
+
+// SetTaxPercent reads the tax rate from configuration, keeping 0 when value is empty
+func SetTaxPercent(value string) error {
+	if value == "" {
+		return nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return errors.New("tax must be a percentage between 0 and 100")
+	}
+	TaxPercent = percent
+	return nil
+}
+
+var (
+	ErrInvalidDiscount = errors.New("discount must be an amount or a percentage, e.g. 5000 or 10%")
+	ErrNoInvoice       = errors.New("this visit has no invoice yet")
+)
+
+type InvoiceService struct {
+	repo        *repositories.InvoiceRepository
+	vehicleRepo *repositories.VehicleRepository
+	packageRepo *repositories.PackageRepository
+}
+
+func NewInvoiceService(repo *repositories.InvoiceRepository, vehicleRepo *repositories.VehicleRepository, packageRepo *repositories.PackageRepository) *InvoiceService {
+	return &InvoiceService{repo: repo, vehicleRepo: vehicleRepo, packageRepo: packageRepo}
+}
+
+// GetInvoice returns the invoice of a vehicle, or ErrNoInvoice for visits
+// checked in before invoicing existed whose invoice was never opened
+func (s *InvoiceService) GetInvoice(vehicleID string) (*repositories.Invoice, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	invoice, err := s.repo.FindByVehicleID(vehicleID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoInvoice
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// OpenInvoice bills the vehicle's package at its current catalog price,
+// unless the vehicle has an invoice already
+func (s *InvoiceService) OpenInvoice(vehicleID string) error {
+	if s.repo == nil || s.vehicleRepo == nil {
+		return errors.New("repository is nil")
+	}
+	_, err := s.repo.FindByVehicleID(vehicleID)
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	vehicle, err := s.vehicleRepo.FindByID(vehicleID)
+	if err != nil {
+		return err
+	}
+	invoice := &repositories.Invoice{
+		VehicleID:  vehicle.ID,
+		TaxPercent: TaxPercent,
+		Items:      []repositories.InvoiceItem{s.packageItem(vehicle.Package)},
+	}
+	calculateInvoice(invoice)
+	if err := s.repo.Create(invoice); err != nil {
+		// Someone opened it at the same moment, theirs is as good
+		if _, findErr := s.repo.FindByVehicleID(vehicleID); findErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// chargeInvoice returns the invoice to charge, opening it on the first charge
+// of a visit from before invoicing
+func (s *InvoiceService) chargeInvoice(vehicleID string) (*repositories.Invoice, error) {
+	if err := s.OpenInvoice(vehicleID); err != nil {
+		return nil, err
+	}
+	return s.GetInvoice(vehicleID)
+}
+
+// ChangePackage replaces the package line of an unpaid invoice after the vehicle's package changed
+func (s *InvoiceService) ChangePackage(vehicleID, packageName string) error {
+	invoice, err := s.GetInvoice(vehicleID)
+	if errors.Is(err, ErrNoInvoice) {
+		// Opened from the new package on the first charge
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if invoice.Status == repositories.InvoicePaid {
+		return nil
+	}
+	items := []repositories.InvoiceItem{s.packageItem(packageName)}
+	for _, item := range invoice.Items {
+		if item.Kind != repositories.ItemPackage {
+			items = append(items, item)
+		}
+	}
+	invoice.Items = items
+	return s.saveItems(invoice)
+}
+
+func (s *InvoiceService) AddItem(vehicleID string, input repositories.InvoiceItemRequest) error {
+	invoice, err := s.chargeInvoice(vehicleID)
+	if err != nil {
+		return err
+	}
+	invoice.Items = append(invoice.Items, repositories.InvoiceItem{
+		Kind:        repositories.ItemAddOn,
+		Description: strings.TrimSpace(input.Description),
+		Quantity:    input.Quantity,
+		UnitPrice:   input.UnitPrice,
+	})
+	return s.saveItems(invoice)
+}
+
+// RemoveItem drops an add-on, the package line only changes with the vehicle's package
+func (s *InvoiceService) RemoveItem(vehicleID string, itemID uint) error {
+	invoice, err := s.GetInvoice(vehicleID)
+	if err != nil {
+		return err
+	}
+	items := invoice.Items[:0]
+	for _, item := range invoice.Items {
+		if item.ID != itemID || item.Kind == repositories.ItemPackage {
+			items = append(items, item)
+		}
+	}
+	invoice.Items = items
+	return s.saveItems(invoice)
+}
+
+// SetDiscount takes off an amount ("5000") or a percentage of the subtotal ("10%")
+func (s *InvoiceService) SetDiscount(vehicleID, discount, note string) error {
+	invoice, err := s.chargeInvoice(vehicleID)
+	if err != nil {
+		return err
+	}
+	amount, rate, err := parseDiscount(discount)
+	if err != nil {
+		return err
+	}
+	invoice.Discount = amount
+	invoice.DiscountRate = rate
+	invoice.DiscountNote = strings.TrimSpace(note)
+	return s.saveItems(invoice)
+}
+
+func (s *InvoiceService) Pay(vehicleID string, input repositories.PaymentRequest, actorID uint) error {
+	if !repositories.ValidPaymentMethod(input.Method) {
+		return repositories.ErrInvalidPaymentMethod
+	}
+	invoice, err := s.chargeInvoice(vehicleID)
+	if err != nil {
+		return err
+	}
+	return s.repo.MarkPaid(invoice.ID, &input, actorID)
+}
+
+// IsPaid reports whether the vehicle may be picked up
+func (s *InvoiceService) IsPaid(vehicleID string) (bool, error) {
+	invoice, err := s.GetInvoice(vehicleID)
+	if errors.Is(err, ErrNoInvoice) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return invoice.Status == repositories.InvoicePaid, nil
+}
+
+func (s *InvoiceService) saveItems(invoice *repositories.Invoice) error {
+	if invoice.Status == repositories.InvoicePaid {
+		return repositories.ErrInvoicePaid
+	}
+	calculateInvoice(invoice)
+	return s.repo.SaveItems(invoice)
+}
+
+// packageItem bills a package at its catalog price, or at 0 when it is no longer in the catalog
+func (s *InvoiceService) packageItem(packageName string) repositories.InvoiceItem {
+	item := repositories.InvoiceItem{
+		Kind:        repositories.ItemPackage,
+		Description: packageName,
+		Quantity:    1,
+	}
+	if s.packageRepo != nil {
+		if pkg, err := s.packageRepo.FindByName(packageName); err == nil {
+			item.UnitPrice = pkg.Price
+		}
+	}
+	return item
+}
+
+// calculateInvoice works out the line amounts and totals. The discount
+// never exceeds the subtotal and tax is charged on what remains.
+func calculateInvoice(invoice *repositories.Invoice) {
+	invoice.Subtotal = 0
+	for i := range invoice.Items {
+		item := &invoice.Items[i]
+		item.Amount = int64(item.Quantity) * item.UnitPrice
+		invoice.Subtotal += item.Amount
+	}
+	if invoice.DiscountRate > 0 {
+		invoice.Discount = int64(math.Round(float64(invoice.Subtotal) * invoice.DiscountRate / 100))
+	}
+	if invoice.Discount > invoice.Subtotal {
+		invoice.Discount = invoice.Subtotal
+	}
+	taxable := invoice.Subtotal - invoice.Discount
+	invoice.Tax = int64(math.Round(float64(taxable) * invoice.TaxPercent / 100))
+	invoice.Total = taxable + invoice.Tax
+}
+
+// parseDiscount reads a fixed amount or a percentage, only one of which is set
+func parseDiscount(value string) (int64, float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, 0, nil
+	}
+	if percentText, ok := strings.CutSuffix(value, "%"); ok {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(percentText), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, 0, ErrInvalidDiscount
+		}
+		return 0, percent, nil
+	}
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || amount < 0 {
+		return 0, 0, ErrInvalidDiscount
+	}
+	return amount, 0, nil
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"nevacarwash.com/main/repositories"
+)
+
+const (
+	// MaxFailedLogins wrong passwords in a row lock the account for LockoutDuration
+	MaxFailedLogins = 10
+	LockoutDuration = 30 * time.Minute
+
+	// accountFreeAttempts wrong passwords are allowed before each further try has to wait
+	accountFreeAttempts = 3
+	// ipFreeAttempts wrong passwords within ipWindow are allowed from one address before it has to wait
+	ipFreeAttempts = 10
+	ipWindow       = 15 * time.Minute
+	loginFirstWait = time.Second
+	loginMaxWait   = 15 * time.Minute
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAccountLocked      = errors.New("this account is locked after too many failed logins, try again later or ask a manager to unlock it")
+)
+
+// LoginThrottledError refuses a login that came too soon after failed ones
+type LoginThrottledError struct {
+	Wait time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed logins, try again in %s", e.Wait.Truncate(time.Second)+time.Second)
+}
+
+// dummyHash is compared against when the username is unknown, so those
+// answers take as long as a wrong password and do not reveal which accounts exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+type LoginService struct {
+	userRepo    *repositories.UserRepository
+	attemptRepo *repositories.LoginAttemptRepository
+	twoFactor   *TwoFactorService
+}
+
+func NewLoginService(userRepo *repositories.UserRepository, attemptRepo *repositories.LoginAttemptRepository, twoFactor *TwoFactorService) *LoginService {
+	return &LoginService{userRepo: userRepo, attemptRepo: attemptRepo, twoFactor: twoFactor}
+}
+
+// Authenticate checks a username and password. Wrong passwords slow down
+// further tries from the same address and to the same account with
+// exponential backoff, and enough of them in a row lock the account.
+// Every attempt is written to the login audit log. When NeedsSecondFactor
+// the user is not signed in yet, VerifyCode or CompleteEnrollment finish the login.
+func (s *LoginService) Authenticate(username, password, ip, userAgent string) (*repositories.User, error) {
+	if s.userRepo == nil || s.attemptRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	now := time.Now().UTC()
+	attempt := &repositories.LoginAttempt{Username: username, IP: ip, UserAgent: userAgent}
+
+	failures, last, err := s.attemptRepo.FailuresByIP(ip, now.Add(-ipWindow))
+	if err != nil {
+		return nil, err
+	}
+	if wait := loginWait(failures-ipFreeAttempts, last, now); wait > 0 {
+		attempt.Outcome = repositories.LoginThrottled
+		s.record(attempt)
+		return nil, &LoginThrottledError{Wait: wait}
+	}
+
+	user, err := s.userRepo.FindByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		attempt.Outcome = repositories.LoginUnknownUser
+		s.record(attempt)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	attempt.UserID = &user.ID
+
+	if user.Locked(now) {
+		if user.FailedLogins >= MaxFailedLogins {
+			attempt.Outcome = repositories.LoginLocked
+			s.record(attempt)
+			return nil, ErrAccountLocked
+		}
+		attempt.Outcome = repositories.LoginThrottled
+		s.record(attempt)
+		return nil, &LoginThrottledError{Wait: user.LockedUntil.Sub(now)}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		attempt.Outcome = repositories.LoginBadPassword
+		s.record(attempt)
+		if err := s.countFailure(user.ID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// Failures are only forgotten once the code is right too, or the
+	// password alone would reset the count between guessed codes
+	if NeedsSecondFactor(user) {
+		attempt.Outcome = repositories.LoginNeedsCode
+		s.record(attempt)
+		return user, nil
+	}
+	if err := s.succeed(user, attempt); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// VerifyCode is the second login step of a user with two-factor
+// authentication. Wrong codes count like wrong passwords.
+func (s *LoginService) VerifyCode(user *repositories.User, code, ip, userAgent string) error {
+	if s.twoFactor == nil {
+		return errors.New("two-factor service is nil")
+	}
+	return s.secondStep(user.ID, ip, userAgent, func(user *repositories.User) error {
+		return s.twoFactor.Verify(user, code)
+	})
+}
+
+// CompleteEnrollment is the second login step of a user whose role requires
+// two-factor authentication they have not set up yet. It turns it on with
+// the first code of their new authenticator and returns their recovery codes.
+func (s *LoginService) CompleteEnrollment(user *repositories.User, code, ip, userAgent string) ([]string, error) {
+	if s.twoFactor == nil {
+		return nil, errors.New("two-factor service is nil")
+	}
+	var codes []string
+	err := s.secondStep(user.ID, ip, userAgent, func(user *repositories.User) error {
+		var err error
+		codes, err = s.twoFactor.ConfirmEnrollment(user.ID, code)
+		return err
+	})
+	return codes, err
+}
+
+// secondStep runs check under the same throttling, lockout and audit log as passwords
+func (s *LoginService) secondStep(userID uint, ip, userAgent string, check func(*repositories.User) error) error {
+	if s.userRepo == nil || s.attemptRepo == nil {
+		return errors.New("repository is nil")
+	}
+	now := time.Now().UTC()
+	// Reload the user, the account may have been locked or reset while the code was typed in
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	attempt := &repositories.LoginAttempt{Username: user.Username, UserID: &user.ID, IP: ip, UserAgent: userAgent}
+
+	failures, last, err := s.attemptRepo.FailuresByIP(ip, now.Add(-ipWindow))
+	if err != nil {
+		return err
+	}
+	if wait := loginWait(failures-ipFreeAttempts, last, now); wait > 0 {
+		attempt.Outcome = repositories.LoginThrottled
+		s.record(attempt)
+		return &LoginThrottledError{Wait: wait}
+	}
+	if user.Locked(now) {
+		if user.FailedLogins >= MaxFailedLogins {
+			attempt.Outcome = repositories.LoginLocked
+			s.record(attempt)
+			return ErrAccountLocked
+		}
+		attempt.Outcome = repositories.LoginThrottled
+		s.record(attempt)
+		return &LoginThrottledError{Wait: user.LockedUntil.Sub(now)}
+	}
+
+	if err := check(user); errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrCodeRequired) {
+		attempt.Outcome = repositories.LoginBadCode
+		s.record(attempt)
+		if err := s.countFailure(user.ID, now); err != nil {
+			return err
+		}
+		return ErrInvalidCode
+	} else if err != nil {
+		return err
+	}
+	return s.succeed(user, attempt)
+}
+
+// countFailure counts a wrong password or code against the account, making
+// further tries wait and locking it after MaxFailedLogins
+func (s *LoginService) countFailure(userID uint, now time.Time) error {
+	failures, err := s.userRepo.AddFailedLogin(userID)
+	if err != nil {
+		return err
+	}
+	if failures >= MaxFailedLogins {
+		if err := s.userRepo.LockUntil(userID, now.Add(LockoutDuration)); err != nil {
+			return err
+		}
+		return ErrAccountLocked
+	} else if wait := loginWait(failures-accountFreeAttempts, now, now); wait > 0 {
+		return s.userRepo.LockUntil(userID, now.Add(wait))
+	}
+	return nil
+}
+
+// succeed forgets the account's failed logins and records the successful login
+func (s *LoginService) succeed(user *repositories.User, attempt *repositories.LoginAttempt) error {
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := s.userRepo.ClearFailedLogins(user.ID); err != nil {
+			return err
+		}
+	}
+	attempt.Outcome = repositories.LoginSucceeded
+	s.record(attempt)
+	return nil
+}
+
+// Unlock lifts the lock on an account and forgets its failed logins
+func (s *LoginService) Unlock(userID uint) error {
+	if s.userRepo == nil {
+		return errors.New("repository is nil")
+	}
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return err
+	}
+	return s.userRepo.ClearFailedLogins(userID)
+}
+
+// GetAttempts returns the latest entries of the login audit log
+func (s *LoginService) GetAttempts() ([]repositories.LoginAttempt, error) {
+	if s.attemptRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.attemptRepo.FindRecent(200)
+}
+
+func (s *LoginService) record(attempt *repositories.LoginAttempt) {
+	if err := s.attemptRepo.Create(attempt); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+}
+
+// loginWait is how long to wait after the last failure once over the free
+// attempts, doubling with every further failure
+func loginWait(over int, last, now time.Time) time.Duration {
+	if over <= 0 {
+		return 0
+	}
+	wait := loginFirstWait
+	for i := 1; i < over && wait < loginMaxWait; i++ {
+		wait *= 2
+	}
+	if wait > loginMaxWait {
+		wait = loginMaxWait
+	}
+	return last.Add(wait).Sub(now)
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"nevacarwash.com/main/repositories"
+)
+
+const (
+	// MaxNotificationAttempts is how often a message is tried before it is left for staff to retry
+	MaxNotificationAttempts = 3
+	// NotificationLease is how long an attempt may take before the message is
+	// retried, for a server that stopped while sending
+	NotificationLease = 5 * time.Minute
+)
+
+// PublicURL is the address customers reach the server on, for links in messages
+var PublicURL string
+
+var ErrNotRetryable = errors.New("only failed notifications can be retried")
+
+// TemplatePlaceholders are filled in when a message template is sent
+var TemplatePlaceholders = []string{"{name}", "{plate}", "{queue}", "{package}", "{eta}", "{track_url}", "{business}"}
+
+type NotificationService struct {
+	repo     *repositories.NotificationRepository
+	notifier Notifier
+}
+
+func NewNotificationService(repo *repositories.NotificationRepository, notifier Notifier) *NotificationService {
+	return &NotificationService{repo: repo, notifier: notifier}
+}
+
+// Notify messages the customer that their vehicle moved to the process, when
+// the process has an enabled template and the vehicle has a contact number.
+// Delivery happens in the background so a slow gateway never holds up staff.
+func (s *NotificationService) Notify(vehicle *repositories.Vehicle, process string) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	template, err := s.repo.FindTemplate(process)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	recipient := repositories.NormalizePhone(vehicle.Contact)
+	if !template.Enabled || recipient == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	notification := &repositories.Notification{
+		VehicleID: vehicle.ID,
+		Process:   process,
+		Recipient: recipient,
+		Message:   renderMessage(template.Body, vehicle),
+		Status:    repositories.NotificationPending,
+		ClaimedAt: &now,
+	}
+	if err := s.repo.Create(notification); err != nil {
+		return err
+	}
+	go s.deliver(notification)
+	return nil
+}
+
+// Retry sends a failed notification again right away
+func (s *NotificationService) Retry(vehicleID string, id uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	notification, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if notification.VehicleID != vehicleID {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	claimed, err := s.repo.ClaimRetry(notification.ID, now, now.Add(-NotificationLease))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrNotRetryable
+	}
+	s.deliver(notification)
+	return nil
+}
+
+// RetryFailed keeps retrying failed notifications in the background, waiting
+// longer after each attempt, until they run out of attempts. Attempts that
+// never finished are retried once their lease has run out.
+func (s *NotificationService) RetryFailed(interval time.Duration) {
+	for range time.Tick(interval) {
+		notifications, err := s.repo.FindRetryable(MaxNotificationAttempts, time.Now().Add(-interval), time.Now().Add(-NotificationLease))
+		if err != nil {
+			log.Printf("Failed to load notifications to retry: %v", err)
+			continue
+		}
+		for i := range notifications {
+			notification := &notifications[i]
+			wait := time.Duration(notification.Attempts) * interval
+			if notification.Status == repositories.NotificationFailed && time.Since(notification.UpdatedAt) < wait {
+				continue
+			}
+			// Staff or another server may have retried it since it was loaded
+			now := time.Now()
+			claimed, err := s.repo.ClaimRetry(notification.ID, now, now.Add(-NotificationLease))
+			if err != nil {
+				log.Printf("Failed to claim notification %d: %v", notification.ID, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			s.deliver(notification)
+		}
+	}
+}
+
+func (s *NotificationService) GetNotifications(vehicleID string) ([]repositories.Notification, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByVehicleID(vehicleID)
+}
+
+func (s *NotificationService) GetTemplates() ([]repositories.MessageTemplate, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindTemplates()
+}
+
+func (s *NotificationService) UpdateTemplate(id uint, input repositories.MessageTemplateRequest) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	input.Body = strings.TrimSpace(input.Body)
+	return s.repo.UpdateTemplate(id, &input)
+}
+
+// deliver makes one attempt at sending the notification and records how it went
+func (s *NotificationService) deliver(notification *repositories.Notification) {
+	notification.Attempts++
+	reference, err := s.send(notification)
+	notification.ClaimedAt = nil
+	if err != nil {
+		notification.Status = repositories.NotificationFailed
+		notification.Error = err.Error()
+	} else {
+		now := time.Now().UTC()
+		notification.Status = repositories.NotificationSent
+		notification.Error = ""
+		notification.Reference = reference
+		notification.SentAt = &now
+	}
+	if err := s.repo.Save(notification); err != nil {
+		log.Printf("Failed to record delivery of notification %d: %v", notification.ID, err)
+	}
+}
+
+func (s *NotificationService) send(notification *repositories.Notification) (string, error) {
+	if s.notifier == nil {
+		return "", errors.New("no notifier configured")
+	}
+	return s.notifier.Send(notification.Recipient, notification.Message)
+}
+
+// renderMessage fills in the placeholders of a message template for the vehicle
+func renderMessage(body string, vehicle *repositories.Vehicle) string {
+	trackURL := ""
+	if vehicle.TrackingToken != "" {
+		trackURL = strings.TrimRight(PublicURL, "/") + "/track/" + vehicle.TrackingToken
+	}
+	return strings.NewReplacer(
+		"{name}", vehicle.Name,
+		"{plate}", vehicle.Plate,
+		"{queue}", strconv.Itoa(vehicle.Queue),
+		"{package}", vehicle.Package,
+		"{eta}", clock(vehicle.EstimatedTime),
+		"{track_url}", trackURL,
+		"{business}", BusinessName,
+	).Replace(body)
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier delivers a text message to a customer's phone number and returns
+// the reference the messaging provider gave it, if any
+type Notifier interface {
+	Send(to, message string) (string, error)
+}
+
+// GatewayNotifier posts messages to a WhatsApp or SMS HTTP gateway as
+// {"to": "62812...", "message": "...", "channel": "whatsapp"} with a bearer token.
+// The gateway answers 2xx on success, optionally with {"id": "..."}.
+type GatewayNotifier struct {
+	URL     string
+	Token   string
+	Channel string // whatsapp or sms, passed on to the gateway
+	client  *http.Client
+}
+
+func NewGatewayNotifier(url, token, channel string) *GatewayNotifier {
+	return &GatewayNotifier{URL: url, Token: token, Channel: channel, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (n *GatewayNotifier) Send(to, message string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"to":      internationalPhone(to),
+		"message": message,
+		"channel": n.Channel,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("gateway answered %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(respBody, &result)
+	return result.ID, nil
+}
+
+// FileNotifier writes messages to a file instead of sending them, or to the
+// server log when Path is empty. It stands in for the gateway when testing.
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{Path: path}
+}
+
+func (n *FileNotifier) Send(to, message string) (string, error) {
+	line := fmt.Sprintf("%s to %s: %s", time.Now().UTC().Format(time.RFC3339), to, message)
+	if n.Path == "" {
+		log.Printf("Notification %s", line)
+		return "", nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintln(file, line)
+	return "", err
+}
+
+// internationalPhone writes a local number like "0812..." as "62812...", the form gateways expect
+func internationalPhone(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	number := digits.String()
+	if strings.HasPrefix(number, "0") {
+		return "62" + strings.TrimPrefix(number, "0")
+	}
+	return number
+}
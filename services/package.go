@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+
+	"nevacarwash.com/main/repositories"
+)
+
+type PackageService struct {
+	repo *repositories.PackageRepository
+}
+
+func NewPackageService(repo *repositories.PackageRepository) *PackageService {
+	return &PackageService{repo: repo}
+}
+
+func (s *PackageService) CreatePackage(input *repositories.PackageRequest) (*repositories.Package, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.Create(input)
+}
+
+func (s *PackageService) GetPackages() ([]repositories.Package, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindAll()
+}
+
+func (s *PackageService) GetActivePackages() ([]repositories.Package, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindActive()
+}
+
+func (s *PackageService) GetPackageByID(id uint) (*repositories.Package, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByID(id)
+}
+
+func (s *PackageService) UpdatePackage(id uint, input repositories.PackageRequest) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Update(id, &input)
+}
+
+func (s *PackageService) DeletePackage(id uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Delete(id)
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MinPasswordLength is the shortest password staff may choose
+const MinPasswordLength = 10
+
+var ErrWeakPassword = errors.New("password is too weak")
+
+// commonPasswords are refused however they are written
+var commonPasswords = []string{
+	"password", "passw0rd", "qwerty", "123456", "12345678", "123456789", "1234567890",
+	"iloveyou", "admin", "welcome", "letmein", "carwash", "nevacarwash", "indonesia",
+}
+
+// PasswordRules describes ValidatePassword to people choosing a password
+var PasswordRules = fmt.Sprintf("At least %d characters with three of: lowercase, uppercase, digits and symbols. It may not contain your username or a common password.", MinPasswordLength)
+
+// ValidatePassword checks a new password against the strength rules
+func ValidatePassword(password, username string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return fmt.Errorf("%w: use at least %d characters", ErrWeakPassword, MinPasswordLength)
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < 3 {
+		return fmt.Errorf("%w: mix at least three of lowercase, uppercase, digits and symbols", ErrWeakPassword)
+	}
+	folded := strings.ToLower(password)
+	if name := strings.ToLower(strings.TrimSpace(username)); len(name) >= 3 && strings.Contains(folded, name) {
+		return fmt.Errorf("%w: it may not contain your username", ErrWeakPassword)
+	}
+	for _, common := range commonPasswords {
+		if strings.Contains(folded, common) {
+			return fmt.Errorf("%w: it is too close to a common password", ErrWeakPassword)
+		}
+	}
+	return nil
+}
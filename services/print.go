@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"nevacarwash.com/main/repositories"
+)
+
+// BusinessName heads printed tickets and receipts
+var BusinessName = "Neva Carwash"
+
+// receiptWidth is the number of characters of font A on 58mm thermal paper
+const receiptWidth = 32
+
+// FormatRupiah writes an amount with dots between thousands, e.g. "Rp 35.000"
+func FormatRupiah(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatInt(amount, 10)
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "." + digits[i:]
+	}
+	return sign + "Rp " + digits
+}
+
+// QRCodePNG renders content as a QR code image of the given width in pixels
+func QRCodePNG(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+// TicketESCPOS is the queue ticket handed over at check-in, as ESC/POS
+// commands for a 58mm thermal printer. The QR code links to statusURL.
+func TicketESCPOS(vehicle *repositories.Vehicle, statusURL string) []byte {
+	p := newESCPOS()
+	p.align(alignCenter)
+	p.bold(true)
+	p.line(BusinessName)
+	p.bold(false)
+	p.line(vehicle.Date.In(repositories.BusinessLocation).Format("2006-01-02") + " " + clock(vehicle.EnterTime))
+	p.feed(1)
+	p.line("QUEUE")
+	p.size(3)
+	p.line(strconv.Itoa(vehicle.Queue))
+	p.size(0)
+	p.feed(1)
+	p.align(alignLeft)
+	p.pair("Plate", vehicle.Plate)
+	p.pair("Package", vehicle.Package)
+	p.pair("Ready at", clock(vehicle.EstimatedTime))
+	p.pair("Ticket", vehicle.ID)
+	p.feed(1)
+	p.align(alignCenter)
+	p.qrCode(statusURL)
+	p.line("Scan to follow your wash")
+	p.cut()
+	return p.Bytes()
+}
+
+// ReceiptESCPOS is the paid invoice as ESC/POS commands for a 58mm thermal printer
+func ReceiptESCPOS(vehicle *repositories.Vehicle, invoice *repositories.Invoice) []byte {
+	p := newESCPOS()
+	p.align(alignCenter)
+	p.bold(true)
+	p.line(BusinessName)
+	p.bold(false)
+	p.line(invoice.Number)
+	if invoice.PaidAt != nil {
+		p.line(invoice.PaidAt.In(repositories.BusinessLocation).Format("2006-01-02 15:04"))
+	}
+	p.align(alignLeft)
+	p.rule()
+	p.pair("Plate", vehicle.Plate)
+	p.pair("Name", vehicle.Name)
+	p.rule()
+	for _, item := range invoice.Items {
+		p.line(item.Description)
+		p.pair(fmt.Sprintf("  %d x %s", item.Quantity, FormatRupiah(item.UnitPrice)), FormatRupiah(item.Amount))
+	}
+	p.rule()
+	p.pair("Subtotal", FormatRupiah(invoice.Subtotal))
+	if invoice.Discount > 0 {
+		p.pair("Discount", "-"+FormatRupiah(invoice.Discount))
+	}
+	if invoice.TaxPercent > 0 {
+		p.pair(fmt.Sprintf("Tax %g%%", invoice.TaxPercent), FormatRupiah(invoice.Tax))
+	}
+	p.bold(true)
+	p.pair("TOTAL", FormatRupiah(invoice.Total))
+	p.bold(false)
+	if invoice.Status == repositories.InvoicePaid {
+		p.pair("Paid by", strings.ToUpper(invoice.PaymentMethod))
+		if invoice.Reference != "" {
+			p.pair("Ref", invoice.Reference)
+		}
+	} else {
+		p.pair("Status", "UNPAID")
+	}
+	p.feed(1)
+	p.align(alignCenter)
+	p.line("Thank you!")
+	p.cut()
+	return p.Bytes()
+}
+
+// clock formats t as a time of day in the business time zone
+func clock(t time.Time) string {
+	return t.In(repositories.BusinessLocation).Format("3:04 PM")
+}
+
+const (
+	alignLeft   = 0
+	alignCenter = 1
+)
+
+// escpos builds the byte stream understood by ESC/POS thermal printers
+type escpos struct {
+	bytes.Buffer
+	width int // Characters per line at the current size
+}
+
+func newESCPOS() *escpos {
+	p := &escpos{width: receiptWidth}
+	p.Write([]byte{0x1b, 0x40}) // ESC @, reset the printer
+	return p
+}
+
+func (p *escpos) align(n byte) {
+	p.Write([]byte{0x1b, 0x61, n})
+}
+
+func (p *escpos) bold(on bool) {
+	var n byte
+	if on {
+		n = 1
+	}
+	p.Write([]byte{0x1b, 0x45, n})
+}
+
+// size scales characters, 0 is normal and n makes them n+1 times as wide and tall
+func (p *escpos) size(n byte) {
+	p.Write([]byte{0x1d, 0x21, n<<4 | n})
+	p.width = receiptWidth / (int(n) + 1)
+}
+
+// line prints text, wrapping it at spaces when it is wider than the paper
+func (p *escpos) line(text string) {
+	text = printable(text)
+	if len(text) <= p.width {
+		p.WriteString(text)
+		p.WriteByte('\n')
+		return
+	}
+	for _, part := range wrap(text, p.width) {
+		p.WriteString(part)
+		p.WriteByte('\n')
+	}
+}
+
+func (p *escpos) feed(lines int) {
+	p.WriteString(strings.Repeat("\n", lines))
+}
+
+func (p *escpos) rule() {
+	p.line(strings.Repeat("-", p.width))
+}
+
+// pair prints label on the left and value on the right of one line. When
+// they do not fit the value goes under the label, wrapped and to the right.
+func (p *escpos) pair(label, value string) {
+	label, value = printable(label), printable(value)
+	if gap := p.width - len(label) - len(value); gap >= 1 {
+		p.line(label + strings.Repeat(" ", gap) + value)
+		return
+	}
+	p.line(label)
+	for _, part := range wrap(value, p.width) {
+		p.line(strings.Repeat(" ", p.width-len(part)) + part)
+	}
+}
+
+// wrap breaks text at spaces into lines of at most width characters, cutting
+// words that are longer than a whole line
+func wrap(text string, width int) []string {
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case current == "":
+			current = word
+		case len(current)+1+len(word) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	if current != "" || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// qrCode prints content as a QR code with the printer's own encoder (GS ( k)
+func (p *escpos) qrCode(content string) {
+	data := []byte(content)
+	storeLength := len(data) + 3
+	p.Write([]byte{0x1d, 0x28, 0x6b, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00}) // Model 2
+	p.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x43, 0x06})       // Module size
+	p.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x45, 0x31})       // Error correction M
+	p.Write([]byte{0x1d, 0x28, 0x6b, byte(storeLength), byte(storeLength >> 8), 0x31, 0x50, 0x30})
+	p.Write(data)
+	p.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x51, 0x30}) // Print the stored code
+	p.WriteByte('\n')
+}
+
+// cut feeds the paper past the cutter and cuts it
+func (p *escpos) cut() {
+	p.Write([]byte{0x1d, 0x56, 0x42, 0x00})
+}
+
+// printable replaces what the printer's code page cannot show
+func printable(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		if r >= 0x20 && r < 0x7f {
+			out.WriteRune(r)
+		} else {
+			out.WriteByte('?')
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// useWIB prints times in Western Indonesia Time for the length of the test
+func useWIB(t *testing.T) *time.Location {
+	t.Helper()
+	location := time.FixedZone("WIB", 7*60*60)
+	previous := repositories.BusinessLocation
+	repositories.BusinessLocation = location
+	t.Cleanup(func() { repositories.BusinessLocation = previous })
+	return location
+}
+
+// checkGolden compares printer output byte for byte and shows both streams when they differ
+func checkGolden(t *testing.T, got []byte, want string) {
+	t.Helper()
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("printer output differs\ngot:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestTicketESCPOSWrapsLongPlate(t *testing.T) {
+	wib := useWIB(t)
+	vehicle := &repositories.Vehicle{
+		ID:            "kasir-12",
+		Queue:         7,
+		Plate:         "B 1234 XYZ PLAT SEMENTARA DARI DEALER",
+		Package:       "Mobil",
+		Date:          time.Date(2026, 10, 18, 0, 0, 0, 0, wib),
+		EnterTime:     time.Date(2026, 10, 18, 9, 5, 0, 0, wib),
+		EstimatedTime: time.Date(2026, 10, 18, 9, 50, 0, 0, wib),
+	}
+
+	got := TicketESCPOS(vehicle, "https://neva.example/track/abc123")
+
+	want := "\x1b@" + // Reset
+		"\x1ba\x01" + // Center
+		"\x1bE\x01" + "Neva Carwash\n" + "\x1bE\x00" +
+		"2026-10-18 9:05 AM\n" +
+		"\n" +
+		"QUEUE\n" +
+		"\x1d!\x33" + "7\n" + "\x1d!\x00" + // Four times as large
+		"\n" +
+		"\x1ba\x00" + // Left
+		"Plate\n" + // Too long for one line, the plate wraps under it to the right
+		"  B 1234 XYZ PLAT SEMENTARA DARI\n" +
+		"                          DEALER\n" +
+		"Package                    Mobil\n" +
+		"Ready at                 9:50 AM\n" +
+		"Ticket                  kasir-12\n" +
+		"\n" +
+		"\x1ba\x01" +
+		"\x1d(k\x04\x001A2\x00" + // QR model 2
+		"\x1d(k\x03\x001C\x06" + // Module size
+		"\x1d(k\x03\x001E1" + // Error correction M
+		"\x1d(k\x24\x001P0" + "https://neva.example/track/abc123" + // Store 33 bytes of data
+		"\x1d(k\x03\x001Q0" + "\n" + // Print it
+		"Scan to follow your wash\n" +
+		"\x1dVB\x00" // Cut
+	checkGolden(t, got, want)
+}
+
+func TestReceiptESCPOSWrapsLongNameAndItems(t *testing.T) {
+	useWIB(t)
+	paidAt := time.Date(2026, 10, 18, 3, 10, 0, 0, time.UTC)
+	vehicle := &repositories.Vehicle{
+		Plate: "B 1234 XYZ",
+		Name:  "Raden Mas Bagus Wicaksono Adiningrat",
+	}
+	invoice := &repositories.Invoice{
+		Number: "INV-20261018-0007",
+		Items: []repositories.InvoiceItem{
+			{Description: "Mobil", Quantity: 1, UnitPrice: 35000, Amount: 35000},
+			{Description: "Semir ban dan pembersihan interior menyeluruh", Quantity: 2, UnitPrice: 15000, Amount: 30000},
+		},
+		Subtotal:      65000,
+		Discount:      5000,
+		TaxPercent:    11,
+		Tax:           6600,
+		Total:         66600,
+		Status:        repositories.InvoicePaid,
+		PaymentMethod: "qris",
+		Reference:     "QRIS-00001234567890123456789012345",
+		PaidAt:        &paidAt,
+	}
+
+	got := ReceiptESCPOS(vehicle, invoice)
+
+	want := "\x1b@" + // Reset
+		"\x1ba\x01" + // Center
+		"\x1bE\x01" + "Neva Carwash\n" + "\x1bE\x00" +
+		"INV-20261018-0007\n" +
+		"2026-10-18 10:10\n" +
+		"\x1ba\x00" + // Left
+		"--------------------------------\n" +
+		"Plate                 B 1234 XYZ\n" +
+		"Name\n" + // Too long for one line, the name wraps under it to the right
+		"       Raden Mas Bagus Wicaksono\n" +
+		"                      Adiningrat\n" +
+		"--------------------------------\n" +
+		"Mobil\n" +
+		"  1 x Rp 35.000        Rp 35.000\n" +
+		"Semir ban dan pembersihan\n" + // Descriptions wrap at spaces
+		"interior menyeluruh\n" +
+		"  2 x Rp 15.000        Rp 30.000\n" +
+		"--------------------------------\n" +
+		"Subtotal               Rp 65.000\n" +
+		"Discount               -Rp 5.000\n" +
+		"Tax 11%                 Rp 6.600\n" +
+		"\x1bE\x01" + "TOTAL                  Rp 66.600\n" + "\x1bE\x00" +
+		"Paid by                     QRIS\n" +
+		"Ref\n" + // A reference without spaces is cut at the paper's width
+		"QRIS-000012345678901234567890123\n" +
+		"                              45\n" +
+		"\n" +
+		"\x1ba\x01" +
+		"Thank you!\n" +
+		"\x1dVB\x00" // Cut
+	checkGolden(t, got, want)
+}
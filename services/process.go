@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ProcessWaiting   = "Waiting"
+	ProcessWashing   = "Washing"
+	ProcessFinish    = "Finish"
+	ProcessPickedUp  = "PickedUp"
+	ProcessCancelled = "Cancelled"
+	ProcessAbandoned = "Abandoned"
+)
+
+// processTransitions maps each process to the processes it may move to
+var processTransitions = map[string][]string{
+	ProcessWaiting:   {ProcessWashing, ProcessCancelled, ProcessAbandoned},
+	ProcessWashing:   {ProcessFinish, ProcessCancelled, ProcessAbandoned},
+	ProcessFinish:    {ProcessPickedUp},
+	ProcessPickedUp:  {},
+	ProcessCancelled: {},
+	ProcessAbandoned: {},
+}
+
+var (
+	ErrReasonRequired = errors.New("a reason is required to abandon a vehicle")
+	ErrUnpaid         = errors.New("the invoice must be paid before the vehicle is picked up")
+	ErrOverrideReason = errors.New("a reason is required to hand over an unpaid vehicle")
+)
+
+// TransitionRequest describes a move of a vehicle to another process
+type TransitionRequest struct {
+	Process string
+	BayID   uint   // Bay to wash in, 0 picks the first free one
+	Reason  string // Required when abandoning
+	ActorID uint
+
+	// OverridePayment lets a manager hand over an unpaid vehicle, Reason says why
+	OverridePayment bool
+}
+
+// TransitionError is returned when a vehicle is moved to a process it cannot reach
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	if _, known := processTransitions[e.To]; !known {
+		return fmt.Sprintf("unknown process: %s", e.To)
+	}
+	return fmt.Sprintf("cannot move vehicle from %s to %s", e.From, e.To)
+}
+
+// AllowedTransitions returns the processes reachable from the given one
+func AllowedTransitions(from string) []string {
+	return processTransitions[from]
+}
+
+func ValidateTransition(from, to string) error {
+	for _, next := range processTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
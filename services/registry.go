@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// PlateLookup is what the check-in form fills in for a plate that has been washed before
+type PlateLookup struct {
+	Vehicle *repositories.RegisteredVehicle
+	Visits  int64
+}
+
+type RegistryService struct {
+	repo        *repositories.RegisteredVehicleRepository
+	vehicleRepo *repositories.VehicleRepository
+}
+
+func NewRegistryService(repo *repositories.RegisteredVehicleRepository, vehicleRepo *repositories.VehicleRepository) *RegistryService {
+	return &RegistryService{repo: repo, vehicleRepo: vehicleRepo}
+}
+
+// GetRegisteredVehicles lists registered vehicles for the registry page, optionally filtered by plate
+func (s *RegistryService) GetRegisteredVehicles(query string) ([]repositories.RegisteredVehicle, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.Search(query, 100)
+}
+
+func (s *RegistryService) GetRegisteredVehicleByID(id uint) (*repositories.RegisteredVehicle, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByID(id)
+}
+
+// LookupPlate returns the registered vehicle with the plate and how often it came in
+func (s *RegistryService) LookupPlate(plate string) (*PlateLookup, error) {
+	if s.repo == nil || s.vehicleRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	vehicle, err := s.repo.FindByPlate(plate)
+	if err != nil {
+		return nil, err
+	}
+	visits, err := s.vehicleRepo.CountByRegisteredVehicle(vehicle.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &PlateLookup{Vehicle: vehicle, Visits: visits}, nil
+}
+
+// GetVisits lists every wash of the registered vehicle, newest first
+func (s *RegistryService) GetVisits(id uint) ([]repositories.Vehicle, error) {
+	if s.vehicleRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.vehicleRepo.FindByRegisteredVehicle(id)
+}
+
+func (s *RegistryService) UpdateRegisteredVehicle(id uint, input repositories.RegisteredVehicleRequest) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Update(id, &input)
+}
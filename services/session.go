@@ -0,0 +1,262 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+	"nevacarwash.com/main/repositories"
+)
+
+var (
+	// AccessTokenTTL is how long a signed access token is accepted, it is
+	// renewed from the session's refresh token when it runs out
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long a device stays signed in without logging in again
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var ErrSessionExpired = errors.New("session expired, please log in again")
+
+// SetSessionLifetimes reads the token lifetimes from configuration, keeping the defaults for empty values
+func SetSessionLifetimes(access, refresh string) error {
+	if access != "" {
+		ttl, err := time.ParseDuration(access)
+		if err != nil || ttl <= 0 {
+			return errors.New("access token lifetime must be a duration like 15m")
+		}
+		AccessTokenTTL = ttl
+	}
+	if refresh != "" {
+		ttl, err := time.ParseDuration(refresh)
+		if err != nil || ttl <= 0 {
+			return errors.New("refresh token lifetime must be a duration like 720h")
+		}
+		RefreshTokenTTL = ttl
+	}
+	return nil
+}
+
+// SessionTokens is what a device receives when it logs in
+type SessionTokens struct {
+	AccessToken     string
+	AccessExpiresAt time.Time
+	RefreshToken    string // Empty when the device keeps its current refresh token
+	SessionID       uint
+	ExpiresAt       time.Time // When the refresh token, and so the session, runs out
+	Role            string
+}
+
+type SessionService struct {
+	repo *repositories.SessionRepository
+}
+
+func NewSessionService(repo *repositories.SessionRepository) *SessionService {
+	return &SessionService{repo: repo}
+}
+
+// StartSession signs the user in on a new device
+func (s *SessionService) StartSession(user *repositories.User, userAgent, ip string) (*SessionTokens, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	session := &repositories.Session{
+		UserID:     user.ID,
+		TokenHash:  hashToken(refreshToken),
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+	}
+	if err := s.repo.Create(session); err != nil {
+		return nil, err
+	}
+	if err := s.repo.PruneRetired(now); err != nil {
+		return nil, err
+	}
+	accessToken, accessExpiresAt, err := issueAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{
+		AccessToken:     accessToken,
+		AccessExpiresAt: accessExpiresAt,
+		RefreshToken:    refreshToken,
+		SessionID:       session.ID,
+		ExpiresAt:       session.ExpiresAt,
+		Role:            user.Role,
+	}, nil
+}
+
+// refreshReuseGrace is how long a refresh token that was just replaced still
+// works, so requests sent together with the one that replaced it get through
+const refreshReuseGrace = 30 * time.Second
+
+// Refresh issues a new access token for the session holding the refresh
+// token, and replaces the refresh token with a new one. The token carries the
+// user's current role, so role changes apply within one access token lifetime.
+func (s *SessionService) Refresh(refreshToken, ip string) (*SessionTokens, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	hash := hashToken(refreshToken)
+	now := time.Now().UTC()
+	session, err := s.repo.FindByTokenHash(hash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.refreshRetired(hash, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRefreshable(session, now); err != nil {
+		return nil, err
+	}
+	nextToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.repo.Rotate(session.ID, hash, hashToken(nextToken), ip, now)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// A request sent together with this one replaced the token first
+		nextToken = ""
+	}
+	return sessionTokens(session, nextToken)
+}
+
+// refreshRetired answers a refresh token that was already replaced. Shortly
+// after the swap it still gets an access token; later it can only be a copy,
+// so the session is signed out for whoever holds it.
+func (s *SessionService) refreshRetired(hash string, now time.Time) (*SessionTokens, error) {
+	retired, err := s.repo.FindRetired(hash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &retired.Session
+	if err := checkRefreshable(session, now); err != nil {
+		return nil, err
+	}
+	if now.Sub(retired.RetiredAt) > refreshReuseGrace {
+		if err := s.repo.Revoke(session.ID, session.UserID, now); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		return nil, ErrSessionExpired
+	}
+	return sessionTokens(session, "")
+}
+
+// checkRefreshable refuses sessions that ended or whose user has to log in again
+func checkRefreshable(session *repositories.Session, now time.Time) error {
+	if !session.Active(now) || session.User.ID == 0 {
+		return ErrSessionExpired
+	}
+	// Members of a role that now requires two-factor authentication log in
+	// again to set it up
+	if RequiresTwoFactor(session.User.Role) && !session.User.TwoFactorEnabled() {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// sessionTokens issues an access token for the session. refreshToken is empty
+// when the device keeps the one it has.
+func sessionTokens(session *repositories.Session, refreshToken string) (*SessionTokens, error) {
+	accessToken, accessExpiresAt, err := issueAccessToken(&session.User, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{
+		AccessToken:     accessToken,
+		AccessExpiresAt: accessExpiresAt,
+		RefreshToken:    refreshToken,
+		SessionID:       session.ID,
+		ExpiresAt:       session.ExpiresAt,
+		Role:            session.User.Role,
+	}, nil
+}
+
+// IsActive reports whether access tokens of the session are still accepted
+func (s *SessionService) IsActive(sessionID uint) bool {
+	if s.repo == nil {
+		return false
+	}
+	session, err := s.repo.FindByID(sessionID)
+	return err == nil && session.Active(time.Now())
+}
+
+// GetSessions lists the devices the user is signed in on
+func (s *SessionService) GetSessions(userID uint) ([]repositories.Session, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindActiveByUser(userID, time.Now())
+}
+
+// Revoke signs the user out on one device
+func (s *SessionService) Revoke(userID, sessionID uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Revoke(sessionID, userID, time.Now())
+}
+
+// RevokeAll signs the user out on every device
+func (s *SessionService) RevokeAll(userID uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.RevokeAll(userID, time.Now())
+}
+
+// RevokeOthers signs the user out on every device but the one they are using
+func (s *SessionService) RevokeOthers(userID, keepSessionID uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.RevokeAllExcept(userID, keepSessionID, time.Now())
+}
+
+// issueAccessToken signs a short lived JWT for the user's session
+func issueAccessToken(user *repositories.User, sessionID uint) (string, time.Time, error) {
+	expiresAt := time.Now().Add(AccessTokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"sid":      sessionID,
+		"exp":      expiresAt.Unix(),
+	})
+	signed, err := token.SignedString([]byte(os.Getenv("SECRET")))
+	return signed, expiresAt, err
+}
+
+// randomToken returns an unguessable refresh token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how refresh tokens are stored, so a leaked database holds no usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"nevacarwash.com/main/repositories"
+)
+
+func TestRefreshReplacesTokenAndRevokesSessionWhenOldOneReturns(t *testing.T) {
+	t.Setenv("SECRET", "test-secret")
+	db := openTestDB(t)
+	repo := repositories.NewSessionRepository(db)
+	service := NewSessionService(repo)
+	user := &repositories.User{Username: "kasir", Role: repositories.RoleCashier}
+	if err := repositories.NewUserRepository(db).Create(user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	login, err := service.StartSession(user, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	first, err := service.Refresh(login.RefreshToken, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if first.RefreshToken == "" || first.RefreshToken == login.RefreshToken {
+		t.Fatalf("refresh kept the refresh token, want a new one")
+	}
+
+	// A request sent together with the first refresh still gets in, without a new refresh token
+	racing, err := service.Refresh(login.RefreshToken, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("refresh right after rotation: %v", err)
+	}
+	if racing.AccessToken == "" || racing.RefreshToken != "" {
+		t.Errorf("refresh right after rotation = access %q refresh %q, want only an access token", racing.AccessToken, racing.RefreshToken)
+	}
+
+	second, err := service.Refresh(first.RefreshToken, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("refresh with the new token: %v", err)
+	}
+
+	// Later on the replaced token can only be a copy
+	past := time.Now().Add(-time.Hour).UTC()
+	if err := db.Model(&repositories.RetiredRefreshToken{}).Where("1 = 1").Update("retired_at", past).Error; err != nil {
+		t.Fatalf("age retired tokens: %v", err)
+	}
+	if _, err := service.Refresh(login.RefreshToken, "127.0.0.1"); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("reused refresh token error = %v, want %v", err, ErrSessionExpired)
+	}
+	if service.IsActive(login.SessionID) {
+		t.Errorf("session still active after its old refresh token came back")
+	}
+	if _, err := service.Refresh(second.RefreshToken, "127.0.0.1"); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("current refresh token after reuse error = %v, want %v", err, ErrSessionExpired)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// VehicleStatus is what a customer sees of their own visit on the public
+// status page, nothing in it identifies other customers
+type VehicleStatus struct {
+	Queue         int        `json:"queue"`
+	Plate         string     `json:"plate"`
+	Package       string     `json:"package"`
+	Process       string     `json:"process"`
+	Position      int        `json:"position"` // Vehicles waiting ahead, counted while the vehicle waits
+	EstimatedTime time.Time  `json:"estimated_time"`
+	FinishTime    *time.Time `json:"finish_time"`
+}
+
+type TrackingService struct {
+	vehicleRepo *repositories.VehicleRepository
+}
+
+func NewTrackingService(vehicleRepo *repositories.VehicleRepository) *TrackingService {
+	return &TrackingService{vehicleRepo: vehicleRepo}
+}
+
+// GetStatus returns the status of the visit with the tracking token
+func (s *TrackingService) GetStatus(token string) (*VehicleStatus, error) {
+	if s.vehicleRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	vehicle, err := s.vehicleRepo.FindByTrackingToken(token)
+	if err != nil {
+		return nil, err
+	}
+	status := &VehicleStatus{
+		Queue:         vehicle.Queue,
+		Plate:         vehicle.Plate,
+		Package:       vehicle.Package,
+		Process:       vehicle.Process,
+		EstimatedTime: vehicle.EstimatedTime,
+		FinishTime:    vehicle.FinishTime,
+	}
+	if vehicle.Process == ProcessWaiting {
+		if status.Position, err = s.waitingAhead(vehicle.ID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// waitingAhead counts the waiting vehicles queued before the vehicle
+func (s *TrackingService) waitingAhead(id string) (int, error) {
+	active, err := s.vehicleRepo.FindActive()
+	if err != nil {
+		return 0, err
+	}
+	ahead := 0
+	for _, vehicle := range active {
+		if vehicle.ID == id {
+			break
+		}
+		if vehicle.Process == ProcessWaiting {
+			ahead++
+		}
+	}
+	return ahead, nil
+}
+
+// BoardEntry is one vehicle on the public queue board, shown by queue number only
+type BoardEntry struct {
+	Queue int    `json:"queue"`
+	Bay   string `json:"bay,omitempty"`
+	Ready string `json:"ready,omitempty"` // Estimated or actual finish, as a time of day
+}
+
+// QueueBoard is what the waiting room TV shows
+type QueueBoard struct {
+	Washing  []BoardEntry `json:"washing"`
+	Waiting  []BoardEntry `json:"waiting"`
+	Finished []BoardEntry `json:"finished"` // Ready for pickup
+}
+
+// GetBoard lists the vehicles on today's queue by process, without anything identifying their owners
+func (s *TrackingService) GetBoard() (*QueueBoard, error) {
+	if s.vehicleRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	active, err := s.vehicleRepo.FindActive()
+	if err != nil {
+		return nil, err
+	}
+	finished, err := s.vehicleRepo.FindByProcess(ProcessFinish)
+	if err != nil {
+		return nil, err
+	}
+
+	board := &QueueBoard{Washing: []BoardEntry{}, Waiting: []BoardEntry{}, Finished: []BoardEntry{}}
+	for _, vehicle := range active {
+		entry := BoardEntry{Queue: vehicle.Queue, Ready: clock(vehicle.EstimatedTime)}
+		if vehicle.Process == ProcessWashing {
+			if vehicle.Bay != nil {
+				entry.Bay = vehicle.Bay.Name
+			}
+			board.Washing = append(board.Washing, entry)
+		} else {
+			board.Waiting = append(board.Waiting, entry)
+		}
+	}
+	for _, vehicle := range finished {
+		entry := BoardEntry{Queue: vehicle.Queue}
+		if vehicle.FinishTime != nil {
+			entry.Ready = clock(*vehicle.FinishTime)
+		}
+		board.Finished = append(board.Finished, entry)
+	}
+	return board, nil
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"nevacarwash.com/main/repositories"
+)
+
+const (
+	// RecoveryCodeCount recovery codes are handed out when two-factor authentication is turned on
+	RecoveryCodeCount = 10
+	// LoginChallengeTTL is how long the code can be entered after the password was accepted
+	LoginChallengeTTL = 5 * time.Minute
+)
+
+var (
+	ErrCodeRequired      = errors.New("enter the code from your authenticator app")
+	ErrInvalidCode       = errors.New("that code is not valid, check the clock on your phone or use a recovery code")
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication is already on")
+	ErrTwoFactorOff      = errors.New("two-factor authentication is not on")
+	ErrTwoFactorRequired = errors.New("your role has to use two-factor authentication")
+	ErrTwoFactorSetup    = errors.New("your role has to use two-factor authentication, set it up by logging in on the website first")
+	ErrChallengeExpired  = errors.New("the login took too long, please enter your password again")
+)
+
+// twoFactorRoles have to use two-factor authentication, their members set it
+// up on their next login
+var twoFactorRoles = []string{repositories.RoleOwner, repositories.RoleAdmin}
+
+// SetTwoFactorRoles reads the roles that have to use two-factor authentication
+// from a comma separated list. An empty value keeps owners and admins, "none"
+// leaves it up to every user.
+func SetTwoFactorRoles(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if value == "none" {
+		twoFactorRoles = nil
+		return nil
+	}
+	var roles []string
+	for _, role := range strings.Split(value, ",") {
+		role = strings.TrimSpace(role)
+		if !repositories.ValidRole(role) {
+			return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+		}
+		roles = append(roles, role)
+	}
+	twoFactorRoles = roles
+	return nil
+}
+
+// RequiresTwoFactor reports whether the role has to use two-factor authentication
+func RequiresTwoFactor(role string) bool {
+	for _, r := range twoFactorRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsSecondFactor reports whether the user's login takes a second step
+// after the password, either entering a code or setting up an authenticator
+func NeedsSecondFactor(user *repositories.User) bool {
+	return user.TwoFactorEnabled() || RequiresTwoFactor(user.Role)
+}
+
+// TwoFactorEnrollment is what a user scans to add the account to their authenticator app
+type TwoFactorEnrollment struct {
+	Secret string
+	URL    string
+	QRCode []byte // PNG of URL
+}
+
+type TwoFactorService struct {
+	userRepo *repositories.UserRepository
+	codeRepo *repositories.RecoveryCodeRepository
+}
+
+func NewTwoFactorService(userRepo *repositories.UserRepository, codeRepo *repositories.RecoveryCodeRepository) *TwoFactorService {
+	return &TwoFactorService{userRepo: userRepo, codeRepo: codeRepo}
+}
+
+// BeginEnrollment gives the user a new authenticator secret. Codes are only
+// asked for once ConfirmEnrollment proves the app was set up.
+func (s *TwoFactorService) BeginEnrollment(userID uint) (*TwoFactorEnrollment, error) {
+	if s.userRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.SetTOTPSecret(user.ID, secret); err != nil {
+		return nil, err
+	}
+	return newEnrollment(user.Username, secret)
+}
+
+// GetEnrollment returns the enrollment the user started and has not confirmed yet
+func (s *TwoFactorService) GetEnrollment(userID uint) (*TwoFactorEnrollment, error) {
+	if s.userRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorOff
+	}
+	return newEnrollment(user.Username, user.TOTPSecret)
+}
+
+// ResumeEnrollment returns the enrollment the user started, or begins one when
+// there is none. Showing the page again keeps the secret their app may
+// already have scanned.
+func (s *TwoFactorService) ResumeEnrollment(userID uint) (*TwoFactorEnrollment, error) {
+	enrollment, err := s.GetEnrollment(userID)
+	if errors.Is(err, ErrTwoFactorOff) {
+		return s.BeginEnrollment(userID)
+	}
+	return enrollment, err
+}
+
+// ConfirmEnrollment turns two-factor authentication on once the user enters
+// a code from the newly set up app, and returns their recovery codes
+func (s *TwoFactorService) ConfirmEnrollment(userID uint, code string) ([]string, error) {
+	if s.userRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorOff
+	}
+	now := time.Now()
+	step, ok := matchTOTP(user.TOTPSecret, normalizeCode(code), now)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, err := s.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.EnableTOTP(user.ID, step, now); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks an authenticator code, or uses up one of the user's recovery
+// codes. A code is only accepted once.
+func (s *TwoFactorService) Verify(user *repositories.User, code string) error {
+	if s.userRepo == nil || s.codeRepo == nil {
+		return errors.New("repository is nil")
+	}
+	if !user.TwoFactorEnabled() {
+		return ErrTwoFactorOff
+	}
+	code = normalizeCode(code)
+	if code == "" {
+		return ErrCodeRequired
+	}
+	now := time.Now()
+	if step, ok := matchTOTP(user.TOTPSecret, code, now); ok {
+		err := s.userRepo.UseTOTPStep(user.ID, step)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidCode
+		}
+		return err
+	}
+	err := s.codeRepo.Use(user.ID, hashToken(code), now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking
+// their password and a code
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID uint, password, code string) ([]string, error) {
+	if s.userRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.confirm(user, password, code); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(user.ID)
+}
+
+// RecoveryCodesLeft returns how many recovery codes the user has not used yet
+func (s *TwoFactorService) RecoveryCodesLeft(userID uint) (int64, error) {
+	if s.codeRepo == nil {
+		return 0, errors.New("repository is nil")
+	}
+	return s.codeRepo.CountUnused(userID)
+}
+
+// Disable turns two-factor authentication off for a user whose role does not
+// require it, after checking their password and a code
+func (s *TwoFactorService) Disable(userID uint, password, code string) error {
+	if s.userRepo == nil {
+		return errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if RequiresTwoFactor(user.Role) {
+		return ErrTwoFactorRequired
+	}
+	if err := s.confirm(user, password, code); err != nil {
+		return err
+	}
+	return s.userRepo.DisableTOTP(user.ID)
+}
+
+// Reset removes the authenticator of a user who lost it, so they can set up
+// a new one. Only owners may reset owners.
+func (s *TwoFactorService) Reset(actorRole string, userID uint) (*repositories.User, error) {
+	if s.userRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == repositories.RoleOwner && actorRole != repositories.RoleOwner {
+		return nil, ErrRoleForbidden
+	}
+	if !user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorOff
+	}
+	return user, s.userRepo.DisableTOTP(user.ID)
+}
+
+// IssueLoginChallenge signs a short lived token saying the user got their
+// password right, the second login step asks for it
+func (s *TwoFactorService) IssueLoginChallenge(user *repositories.User) (string, time.Time, error) {
+	expiresAt := time.Now().Add(LoginChallengeTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  user.ID,
+		"exp": expiresAt.Unix(),
+	})
+	signed, err := token.SignedString(loginChallengeKey())
+	return signed, expiresAt, err
+}
+
+// LoginChallengeUser returns the user a login challenge was issued to
+func (s *TwoFactorService) LoginChallengeUser(token string) (*repositories.User, error) {
+	if s.userRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return loginChallengeKey(), nil
+	})
+	id, ok := claims["id"].(float64)
+	if err != nil || !ok {
+		return nil, ErrChallengeExpired
+	}
+	user, err := s.userRepo.FindByID(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !NeedsSecondFactor(user)) {
+		return nil, ErrChallengeExpired
+	}
+	return user, err
+}
+
+// confirm checks both factors before the user's two-factor settings change, a
+// stolen session alone is not enough
+func (s *TwoFactorService) confirm(user *repositories.User, password, code string) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return s.Verify(user, code)
+}
+
+func (s *TwoFactorService) newRecoveryCodes(userID uint) ([]string, error) {
+	if s.codeRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		hashes[i] = hashToken(code)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	if err := s.codeRepo.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func newEnrollment(username, secret string) (*TwoFactorEnrollment, error) {
+	url := totpURL(BusinessName, username, secret)
+	png, err := QRCodePNG(url, 256)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactorEnrollment{Secret: secret, URL: url, QRCode: png}, nil
+}
+
+// normalizeCode drops the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+// loginChallengeKey signs login challenges. It differs from the access token
+// key so a challenge can never pass as a signed in session.
+func loginChallengeKey() []byte {
+	return []byte(os.Getenv("SECRET") + ":two-factor")
+}
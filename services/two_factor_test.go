@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// newTestTwoFactor returns the service on a fresh migrated SQLite database,
+// with an owner who turned two-factor authentication on, their authenticator
+// key, the step of the code they confirmed with and their recovery codes
+func newTestTwoFactor(t *testing.T) (*TwoFactorService, *repositories.UserRepository, uint, []byte, int64, []string) {
+	t.Helper()
+	db := openTestDB(t)
+
+	users := repositories.NewUserRepository(db)
+	service := NewTwoFactorService(users, repositories.NewRecoveryCodeRepository(db))
+	user := &repositories.User{Username: "owner", Role: repositories.RoleOwner}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	enrollment, err := service.BeginEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("begin enrollment: %v", err)
+	}
+	key, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	step := time.Now().Unix() / totpPeriod
+	codes, err := service.ConfirmEnrollment(user.ID, totpCode(key, step))
+	if err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+	return service, users, user.ID, key, step, codes
+}
+
+func TestVerifyAcceptsEachTimeStepOnce(t *testing.T) {
+	service, users, userID, key, step, _ := newTestTwoFactor(t)
+	verify := func(code string) error {
+		user, err := users.FindByID(userID)
+		if err != nil {
+			t.Fatalf("load user: %v", err)
+		}
+		return service.Verify(user, code)
+	}
+
+	// The code used to turn it on was spent, and so was everything before it
+	if err := verify(totpCode(key, step)); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reusing the enrollment code: got %v, want ErrInvalidCode", err)
+	}
+	if err := verify(totpCode(key, step-1)); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("code of an earlier step: got %v, want ErrInvalidCode", err)
+	}
+	next := totpCode(key, step+1)
+	if err := verify(next); err != nil {
+		t.Fatalf("code of the next step: %v", err)
+	}
+	if err := verify(next); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replaying the next step: got %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestVerifyAcceptsEachRecoveryCodeOnce(t *testing.T) {
+	service, users, userID, _, _, codes := newTestTwoFactor(t)
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), RecoveryCodeCount)
+	}
+	user, err := users.FindByID(userID)
+	if err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+
+	if err := service.Verify(user, codes[0]); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if err := service.Verify(user, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("second use of a recovery code: got %v, want ErrInvalidCode", err)
+	}
+	// People copy codes in capitals and without the dash
+	typed := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if err := service.Verify(user, typed); err != nil {
+		t.Errorf("recovery code typed as %q: %v", typed, err)
+	}
+
+	left, err := service.RecoveryCodesLeft(userID)
+	if err != nil {
+		t.Fatalf("count recovery codes: %v", err)
+	}
+	if left != RecoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", left, RecoveryCodeCount-2)
+	}
+}
@@ -1,9 +1,66 @@
 package services
 
 import (
+	"errors"
+
 	"nevacarwash.com/main/repositories"
 )
 
+var (
+	ErrInvalidRole   = errors.New("unknown role")
+	ErrRoleForbidden = errors.New("only an owner can grant or revoke the owner role")
+	ErrLastOwner     = errors.New("the last owner cannot be demoted")
+)
+
 type UserService struct {
 	repo *repositories.UserRepository
 }
+
+func NewUserService(repo *repositories.UserRepository) *UserService {
+	return &UserService{repo: repo}
+}
+
+func (s *UserService) GetUsers() ([]repositories.User, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindAll()
+}
+
+func (s *UserService) GetUserByID(id uint) (*repositories.User, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByID(id)
+}
+
+// AssignRole changes a user's role on behalf of an actor holding actorRole.
+// Only owners may touch the owner role, and there is always one owner left.
+func (s *UserService) AssignRole(actorRole string, userID uint, role string) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	if !repositories.ValidRole(role) {
+		return ErrInvalidRole
+	}
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == role {
+		return nil
+	}
+	if (role == repositories.RoleOwner || user.Role == repositories.RoleOwner) && actorRole != repositories.RoleOwner {
+		return ErrRoleForbidden
+	}
+	if user.Role == repositories.RoleOwner {
+		owners, err := s.repo.CountByRole(repositories.RoleOwner)
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return ErrLastOwner
+		}
+	}
+	return s.repo.UpdateRole(userID, role)
+}
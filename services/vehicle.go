@@ -2,29 +2,76 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"nevacarwash.com/main/repositories"
 )
 
 type ProcessVehicles struct {
-	Process   string                 // The status name (e.g., "Python", "Go").
+	Process  string                 // The status name (e.g., "Python", "Go").
 	Vehicles []repositories.Vehicle // The list of vehicles for this status.
 }
 
+// BayVehicle pairs a wash bay with the vehicle currently washed in it, if any
+type BayVehicle struct {
+	Bay     repositories.Bay
+	Vehicle *repositories.Vehicle
+}
+
+var (
+	ErrNoBayAvailable = errors.New("no free wash bay for this vehicle")
+	ErrPaidVisit      = errors.New("a paid visit cannot be deleted, its invoice is part of the accounts")
+)
+
 type VehicleService struct {
-	repo *repositories.VehicleRepository
+	repo          *repositories.VehicleRepository
+	eventRepo     *repositories.VehicleEventRepository
+	bayRepo       *repositories.BayRepository
+	packageRepo   *repositories.PackageRepository
+	customerRepo  *repositories.CustomerRepository
+	registryRepo  *repositories.RegisteredVehicleRepository
+	invoices      *InvoiceService
+	notifications *NotificationService
+	webhooks      *WebhookService
+	estimator     *Estimator
+	broker        *Broker
 }
 
-func NewVehicleService(repo *repositories.VehicleRepository) *VehicleService {
-	return &VehicleService{repo: repo}
+func NewVehicleService(repo *repositories.VehicleRepository, eventRepo *repositories.VehicleEventRepository, bayRepo *repositories.BayRepository, packageRepo *repositories.PackageRepository, customerRepo *repositories.CustomerRepository, registryRepo *repositories.RegisteredVehicleRepository, invoices *InvoiceService, notifications *NotificationService, webhooks *WebhookService, estimator *Estimator, broker *Broker) *VehicleService {
+	return &VehicleService{repo: repo, eventRepo: eventRepo, bayRepo: bayRepo, packageRepo: packageRepo, customerRepo: customerRepo, registryRepo: registryRepo, invoices: invoices, notifications: notifications, webhooks: webhooks, estimator: estimator, broker: broker}
 }
 
 func (s *VehicleService) CreateVehicle(input *repositories.CreateVehicleRequest) (string, error) {
 	if s.repo == nil {
 		return "", errors.New("repository is nil")
 	}
+	if err := s.resolveCustomer(input); err != nil {
+		return "", err
+	}
+	if err := s.resolveRegisteredVehicle(input); err != nil {
+		return "", err
+	}
 	id, err := s.repo.Create(input)
-	return id, err
+	if err != nil {
+		return "", err
+	}
+	actorID, _ := strconv.ParseUint(input.UID, 10, 64)
+	if err := s.recordEvent(id, uint(actorID), repositories.EventCreated, "", ProcessWaiting, ""); err != nil {
+		return "", err
+	}
+	if s.invoices == nil {
+		return "", errors.New("invoice service is nil")
+	}
+	if err := s.invoices.OpenInvoice(id); err != nil {
+		return "", err
+	}
+	s.refreshEstimates()
+	s.publish(VehicleChange{Type: ChangeCreated, VehicleID: id, Process: ProcessWaiting}, nil)
+	return id, nil
 }
 
 func (s *VehicleService) GetVehicleByID(id string) (*repositories.Vehicle, error) {
@@ -34,11 +81,54 @@ func (s *VehicleService) GetVehicleByID(id string) (*repositories.Vehicle, error
 	return s.repo.FindByID(id)
 }
 
-func (s *VehicleService) UpdateVehicle(id string, input repositories.CreateVehicleRequest) error {
+func (s *VehicleService) UpdateVehicle(id string, input repositories.CreateVehicleRequest, actorID uint) error {
 	if s.repo == nil {
 		return errors.New("repository is nil")
 	}
-	return s.repo.Update(id, &input)
+	vehicle, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+
+	// Process changes go through the state machine, checked against the
+	// edited vehicle before anything is saved so a refused move leaves it as it was
+	var next *transition
+	if input.Process != "" && input.Process != vehicle.Process {
+		edited := *vehicle
+		edited.Package = input.Package
+		next, err = s.checkTransition(&edited, TransitionRequest{Process: input.Process, ActorID: actorID})
+		if err != nil {
+			return err
+		}
+	}
+	input.Process = vehicle.Process
+	if input.CustomerID != 0 || input.Contact != vehicle.Contact {
+		if err := s.resolveCustomer(&input); err != nil {
+			return err
+		}
+	}
+	if vehicle.RegisteredVehicleID == nil || repositories.NormalizePlate(input.Plate) != repositories.NormalizePlate(vehicle.Plate) {
+		if err := s.resolveRegisteredVehicle(&input); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.Update(id, &input); err != nil {
+		return err
+	}
+	if err := s.recordEvent(id, actorID, repositories.EventUpdated, vehicle.Process, vehicle.Process, ""); err != nil {
+		return err
+	}
+	if input.Package != vehicle.Package && s.invoices != nil {
+		if err := s.invoices.ChangePackage(id, input.Package); err != nil {
+			return err
+		}
+	}
+	if next != nil {
+		return s.applyTransition(vehicle, next, actorID)
+	}
+	s.refreshEstimates()
+	s.publish(VehicleChange{Type: ChangeUpdated, VehicleID: id, Process: vehicle.Process}, nil)
+	return nil
 }
 
 func (s *VehicleService) GetVehiclesByProcess(processes []string) ([]ProcessVehicles, error) {
@@ -50,7 +140,7 @@ func (s *VehicleService) GetVehiclesByProcess(processes []string) ([]ProcessVehi
 			return nil, err
 		}
 		groupedVehicles = append(groupedVehicles, ProcessVehicles{
-			Process:   process,
+			Process:  process,
 			Vehicles: vehicles,
 		})
 	}
@@ -58,6 +148,13 @@ func (s *VehicleService) GetVehiclesByProcess(processes []string) ([]ProcessVehi
 	return groupedVehicles, nil
 }
 
+func (s *VehicleService) SearchVehicles(filter repositories.VehicleFilter) ([]repositories.Vehicle, int64, error) {
+	if s.repo == nil {
+		return nil, 0, errors.New("repository is nil")
+	}
+	return s.repo.Search(filter)
+}
+
 func (s *VehicleService) GetVehiclesByUsername(username string) ([]repositories.Vehicle, error) {
 	if s.repo == nil {
 		return nil, errors.New("repository is nil")
@@ -69,11 +166,353 @@ func (s *VehicleService) DeleteVehicle(id string) error {
 	if s.repo == nil {
 		return errors.New("repository is nil")
 	}
-	return s.repo.Delete(id)
+	vehicle, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	err = s.repo.Delete(id)
+	if errors.Is(err, repositories.ErrInvoicePaid) {
+		return ErrPaidVisit
+	}
+	if err != nil {
+		return err
+	}
+	s.refreshEstimates()
+	s.publish(VehicleChange{Type: ChangeDeleted, VehicleID: id, Process: vehicle.Process}, vehicle)
+	return nil
 }
-func (s *VehicleService) UpdateProcess(id string, process string) error {
+
+// TransitionVehicle moves a vehicle to the requested process if the state
+// machine allows it. Moving to Washing puts the vehicle in the requested bay,
+// or in the first free bay supporting its class.
+func (s *VehicleService) TransitionVehicle(id string, req TransitionRequest) error {
 	if s.repo == nil {
 		return errors.New("repository is nil")
 	}
-	return s.repo.UpdateProcess(id, process)
-}
\ No newline at end of file
+	vehicle, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	next, err := s.checkTransition(vehicle, req)
+	if err != nil {
+		return err
+	}
+	return s.applyTransition(vehicle, next, req.ActorID)
+}
+
+// transition is a move that passed every check and only has to be written
+type transition struct {
+	process string
+	bay     *repositories.Bay
+	note    string
+}
+
+// checkTransition runs every check for moving the vehicle without writing anything
+func (s *VehicleService) checkTransition(vehicle *repositories.Vehicle, req TransitionRequest) (*transition, error) {
+	if err := ValidateTransition(vehicle.Process, req.Process); err != nil {
+		return nil, err
+	}
+	next := &transition{process: req.Process, note: req.Reason}
+	if req.Process == ProcessAbandoned && strings.TrimSpace(req.Reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	if req.Process == ProcessPickedUp {
+		overridden, err := s.checkPaid(vehicle.ID, req)
+		if err != nil {
+			return nil, err
+		}
+		if overridden {
+			next.note = "Picked up unpaid: " + strings.TrimSpace(req.Reason)
+		}
+	}
+	if req.Process == ProcessWashing {
+		bay, err := s.pickBay(vehicle, req.BayID)
+		if err != nil {
+			return nil, err
+		}
+		next.bay = bay
+	}
+	return next, nil
+}
+
+// applyTransition writes a checked transition and tells the listeners
+func (s *VehicleService) applyTransition(vehicle *repositories.Vehicle, next *transition, actorID uint) error {
+	if next.bay != nil {
+		if err := s.repo.StartWashing(vehicle.ID, next.bay.ID); err != nil {
+			return err
+		}
+	} else if err := s.repo.UpdateProcess(vehicle.ID, next.process); err != nil {
+		return err
+	}
+	if err := s.recordEvent(vehicle.ID, actorID, repositories.EventTransition, vehicle.Process, next.process, next.note); err != nil {
+		return err
+	}
+	s.refreshEstimates()
+	s.publish(VehicleChange{Type: ChangeTransitioned, VehicleID: vehicle.ID, Process: next.process}, nil)
+	return nil
+}
+
+// GetUnfinishedVehicles lists every vehicle still waiting or being washed, oldest first
+func (s *VehicleService) GetUnfinishedVehicles() ([]repositories.Vehicle, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindActive()
+}
+
+// CloseDecision tells the end of day routine what to do with an unfinished vehicle
+type CloseDecision struct {
+	VehicleID string
+	Carry     bool
+	Reason    string
+}
+
+// CloseDay carries unfinished vehicles forward to the given date, at the
+// front of its queue, or marks them abandoned with the given reason.
+func (s *VehicleService) CloseDay(decisions []CloseDecision, carryDate string, actorID uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	date, err := repositories.ParseBusinessDate(carryDate)
+	if err != nil {
+		return fmt.Errorf("invalid carry over date: %s", carryDate)
+	}
+
+	var carried []VehicleChange
+	for _, decision := range decisions {
+		if !decision.Carry {
+			err := s.TransitionVehicle(decision.VehicleID, TransitionRequest{
+				Process: ProcessAbandoned,
+				Reason:  decision.Reason,
+				ActorID: actorID,
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", decision.VehicleID, err)
+			}
+			continue
+		}
+
+		vehicle, err := s.repo.FindByID(decision.VehicleID)
+		if err != nil {
+			return err
+		}
+		if vehicle.Process != ProcessWaiting && vehicle.Process != ProcessWashing {
+			return fmt.Errorf("%s: vehicle is already %s", vehicle.ID, vehicle.Process)
+		}
+		if err := s.repo.CarryForward(vehicle.ID, date); err != nil {
+			return err
+		}
+		note := fmt.Sprintf("Carried over from %s to %s", vehicle.Date.In(repositories.BusinessLocation).Format("2006-01-02"), carryDate)
+		if err := s.recordEvent(vehicle.ID, actorID, repositories.EventCarried, vehicle.Process, vehicle.Process, note); err != nil {
+			return err
+		}
+		carried = append(carried, VehicleChange{Type: ChangeUpdated, VehicleID: vehicle.ID, Process: vehicle.Process})
+	}
+
+	s.refreshEstimates()
+	for _, change := range carried {
+		s.publish(change, nil)
+	}
+	return nil
+}
+
+// GetBayBoard lists every active bay with the vehicle being washed in it
+func (s *VehicleService) GetBayBoard() ([]BayVehicle, error) {
+	if s.bayRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	bays, err := s.bayRepo.FindActive()
+	if err != nil {
+		return nil, err
+	}
+	washing, err := s.repo.FindByProcess(ProcessWashing)
+	if err != nil {
+		return nil, err
+	}
+
+	board := make([]BayVehicle, 0, len(bays))
+	for _, bay := range bays {
+		entry := BayVehicle{Bay: bay}
+		for i := range washing {
+			if washing[i].BayID != nil && *washing[i].BayID == bay.ID {
+				entry.Vehicle = &washing[i]
+				break
+			}
+		}
+		board = append(board, entry)
+	}
+	return board, nil
+}
+
+// pickBay returns the requested bay, or the first free one that can wash the vehicle
+func (s *VehicleService) pickBay(vehicle *repositories.Vehicle, bayID uint) (*repositories.Bay, error) {
+	if s.bayRepo == nil || s.packageRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	var class string
+	if pkg, err := s.packageRepo.FindByName(vehicle.Package); err == nil {
+		class = pkg.VehicleClass
+	}
+
+	if bayID != 0 {
+		bay, err := s.bayRepo.FindByID(bayID)
+		if err != nil {
+			return nil, err
+		}
+		if !bay.Active || (class != "" && !bay.Supports(class)) {
+			return nil, ErrNoBayAvailable
+		}
+		return bay, nil
+	}
+
+	bays, err := s.bayRepo.FindFree()
+	if err != nil {
+		return nil, err
+	}
+	for i := range bays {
+		if class == "" || bays[i].Supports(class) {
+			return &bays[i], nil
+		}
+	}
+	return nil, ErrNoBayAvailable
+}
+
+func (s *VehicleService) GetVehicleEvents(id string) ([]repositories.VehicleEvent, error) {
+	if s.eventRepo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.eventRepo.FindByVehicleID(id)
+}
+
+// resolveCustomer links the request to a customer: the one picked on the
+// form, or the one with the contact phone number, created on first visit
+func (s *VehicleService) resolveCustomer(input *repositories.CreateVehicleRequest) error {
+	if s.customerRepo == nil {
+		return errors.New("repository is nil")
+	}
+	if input.CustomerID != 0 {
+		_, err := s.customerRepo.FindByID(input.CustomerID)
+		return err
+	}
+	if repositories.NormalizePhone(input.Contact) == "" {
+		// Walk-in without a phone number
+		return nil
+	}
+	customer, err := s.customerRepo.FindOrCreateByPhone(input.Name, input.Contact)
+	if err != nil {
+		return err
+	}
+	input.CustomerID = customer.ID
+	return nil
+}
+
+// resolveRegisteredVehicle links the request to the vehicle registered with
+// its plate, registering it on first visit, and remembers who brought it in
+// with which package so the next check-in can be filled in
+func (s *VehicleService) resolveRegisteredVehicle(input *repositories.CreateVehicleRequest) error {
+	if s.registryRepo == nil {
+		return errors.New("repository is nil")
+	}
+	registered, err := s.registryRepo.FindOrCreateByPlate(input.Plate)
+	if err != nil {
+		return err
+	}
+	input.Plate = registered.Plate
+	input.RegisteredVehicleID = registered.ID
+
+	if input.CustomerID != 0 {
+		registered.CustomerID = &input.CustomerID
+	}
+	registered.LastPackage = input.Package
+	if registered.VehicleClass == "" && s.packageRepo != nil {
+		if pkg, err := s.packageRepo.FindByName(input.Package); err == nil {
+			registered.VehicleClass = pkg.VehicleClass
+		}
+	}
+	if input.Make != "" {
+		registered.Make = input.Make
+	}
+	if input.Model != "" {
+		registered.Model = input.Model
+	}
+	if input.Color != "" {
+		registered.Color = input.Color
+	}
+	return s.registryRepo.Save(registered)
+}
+
+// checkPaid only lets a vehicle go once its invoice is paid, unless a
+// manager overrides it with a reason. It reports whether it was overridden.
+func (s *VehicleService) checkPaid(id string, req TransitionRequest) (bool, error) {
+	if s.invoices == nil {
+		return false, errors.New("invoice service is nil")
+	}
+	paid, err := s.invoices.IsPaid(id)
+	if err != nil || paid {
+		return false, err
+	}
+	if !req.OverridePayment {
+		return false, ErrUnpaid
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return false, ErrOverrideReason
+	}
+	return true, nil
+}
+
+// recordEvent appends an entry to the vehicle's history
+func (s *VehicleService) recordEvent(vehicleID string, actorID uint, eventType, from, to, note string) error {
+	if s.eventRepo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.eventRepo.Create(&repositories.VehicleEvent{
+		VehicleID:   vehicleID,
+		ActorID:     actorID,
+		Type:        eventType,
+		FromProcess: from,
+		ToProcess:   to,
+		Note:        note,
+	})
+}
+
+// publish tells live views, webhooks and, for transitions, the customer
+// about the change. vehicle is loaded when nil. What cannot be sent never
+// undoes the change, it shows in the delivery logs.
+func (s *VehicleService) publish(change VehicleChange, vehicle *repositories.Vehicle) {
+	s.broker.Publish(change)
+	if vehicle == nil {
+		var err error
+		if vehicle, err = s.repo.FindByID(change.VehicleID); err != nil {
+			log.Printf("Failed to load %s to publish %s: %v", change.VehicleID, change.Type, err)
+			return
+		}
+	}
+	s.webhooks.Dispatch(change, vehicle)
+	if change.Type == ChangeTransitioned && s.notifications != nil {
+		if err := s.notifications.Notify(vehicle, change.Process); err != nil {
+			log.Printf("Failed to notify the customer of %s: %v", vehicle.ID, err)
+		}
+	}
+}
+
+// refreshEstimates recomputes the ETA of every active vehicle after the queue changed
+func (s *VehicleService) refreshEstimates() {
+	if s.estimator == nil {
+		return
+	}
+	vehicles, err := s.repo.FindActive()
+	if err != nil {
+		log.Printf("Failed to load active vehicles: %v", err)
+		return
+	}
+	estimates, err := s.estimator.Estimate(vehicles, time.Now())
+	if err != nil {
+		log.Printf("Failed to estimate finish times: %v", err)
+		return
+	}
+	for id, finish := range estimates {
+		if err := s.repo.UpdateEstimatedTime(id, finish); err != nil {
+			log.Printf("Failed to update estimate for %s: %v", id, err)
+		}
+	}
+}
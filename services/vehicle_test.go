@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"gorm.io/gorm"
+	"nevacarwash.com/main/database"
+	"nevacarwash.com/main/repositories"
+)
+
+// openTestDB migrates a fresh SQLite database, opened the way the server opens it
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("DB", "sqlite")
+	t.Setenv("DATABASE_PATH", filepath.Join(t.TempDir(), "test.db"))
+	if err := database.InitializeDatabaseLayer(); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.MigrateUp(false, io.Discard); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	db := database.GetDB()
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestUpdateVehicleRefusedMoveLeavesDetailsUnchanged(t *testing.T) {
+	db := openTestDB(t)
+	vehicles := repositories.NewVehicleRepository(db)
+	events := repositories.NewVehicleEventRepository(db)
+	packages := repositories.NewPackageRepository(db)
+	bays := repositories.NewBayRepository(db)
+	service := NewVehicleService(vehicles, events, bays, packages,
+		repositories.NewCustomerRepository(db), repositories.NewRegisteredVehicleRepository(db),
+		NewInvoiceService(repositories.NewInvoiceRepository(db), vehicles, packages), nil, nil,
+		NewEstimator(packages, events, bays), NewBroker())
+
+	user := &repositories.User{Username: "kasir", Role: repositories.RoleCashier}
+	if err := repositories.NewUserRepository(db).Create(user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	id, err := service.CreateVehicle(&repositories.CreateVehicleRequest{
+		UID:     strconv.FormatUint(uint64(user.ID), 10),
+		Name:    "Budi",
+		Package: "Mobil",
+		Plate:   "B 1234 XYZ",
+	})
+	if err != nil {
+		t.Fatalf("create vehicle: %v", err)
+	}
+
+	// Abandoning needs a reason, which the edit form never sends
+	err = service.UpdateVehicle(id, repositories.CreateVehicleRequest{
+		Name:    "Budi Santoso",
+		Package: "Mobil",
+		Plate:   "B 5678 ABC",
+		Process: ProcessAbandoned,
+	}, user.ID)
+	if !errors.Is(err, ErrReasonRequired) {
+		t.Fatalf("update error = %v, want %v", err, ErrReasonRequired)
+	}
+
+	vehicle, err := vehicles.FindByID(id)
+	if err != nil {
+		t.Fatalf("find vehicle: %v", err)
+	}
+	if vehicle.Name != "Budi" || vehicle.Plate != "B 1234 XYZ" || vehicle.Process != ProcessWaiting {
+		t.Errorf("vehicle after refused update = %q %q %s, want it unchanged", vehicle.Name, vehicle.Plate, vehicle.Process)
+	}
+	history, err := events.FindByVehicleID(id)
+	if err != nil {
+		t.Fatalf("find events: %v", err)
+	}
+	for _, event := range history {
+		if event.Type == repositories.EventUpdated {
+			t.Errorf("refused update still recorded an %s event", event.Type)
+		}
+	}
+}
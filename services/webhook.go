@@ -0,0 +1,383 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"nevacarwash.com/main/repositories"
+)
+
+// ChangeFinished is sent to webhooks, next to the transition, when a wash is done
+const ChangeFinished = "vehicle.finished"
+
+// WebhookEvents lists the event types a webhook can subscribe to
+var WebhookEvents = []string{ChangeCreated, ChangeUpdated, ChangeTransitioned, ChangeFinished, ChangeDeleted}
+
+const (
+	// MaxWebhookAttempts is how often a delivery is tried before giving up, about a day with the backoff below
+	MaxWebhookAttempts = 10
+	webhookFirstRetry  = 30 * time.Second
+	webhookMaxRetry    = 6 * time.Hour
+	// webhookLease is how long an attempt may take before the delivery is due
+	// again, for a server that stopped while posting
+	webhookLease = 5 * time.Minute
+	// webhookExcerptLength is how much of a failed response is kept for the delivery log
+	webhookExcerptLength = 200
+)
+
+// WebhookPayload is the JSON body posted to webhooks
+type WebhookPayload struct {
+	Event      string         `json:"event"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Vehicle    WebhookVehicle `json:"vehicle"`
+}
+
+// WebhookVehicle is the vehicle as webhooks see it
+type WebhookVehicle struct {
+	ID                  string     `json:"id"`
+	Queue               int        `json:"queue"`
+	Date                string     `json:"date"`
+	Name                string     `json:"name"`
+	Contact             string     `json:"contact"`
+	Plate               string     `json:"plate"`
+	Package             string     `json:"package"`
+	Process             string     `json:"process"`
+	CustomerID          *uint      `json:"customer_id"`
+	RegisteredVehicleID *uint      `json:"registered_vehicle_id"`
+	EnterTime           time.Time  `json:"enter_time"`
+	EstimatedTime       time.Time  `json:"estimated_time"`
+	FinishTime          *time.Time `json:"finish_time"`
+}
+
+type WebhookService struct {
+	repo   *repositories.WebhookRepository
+	client *http.Client
+}
+
+func NewWebhookService(repo *repositories.WebhookRepository) *WebhookService {
+	return &WebhookService{repo: repo, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookService) GetWebhooks() ([]repositories.Webhook, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindAll()
+}
+
+func (s *WebhookService) GetWebhookByID(id uint) (*repositories.Webhook, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindByID(id)
+}
+
+func (s *WebhookService) CreateWebhook(input repositories.WebhookRequest) (*repositories.Webhook, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	webhook := &repositories.Webhook{}
+	if err := applyWebhookRequest(webhook, input); err != nil {
+		return nil, err
+	}
+	return webhook, s.repo.Create(webhook)
+}
+
+func (s *WebhookService) UpdateWebhook(id uint, input repositories.WebhookRequest) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	webhook, err := s.repo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if err := applyWebhookRequest(webhook, input); err != nil {
+		return err
+	}
+	return s.repo.Save(webhook)
+}
+
+func (s *WebhookService) DeleteWebhook(id uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	return s.repo.Delete(id)
+}
+
+// GetDeliveries returns the latest deliveries to a webhook for its delivery log
+func (s *WebhookService) GetDeliveries(webhookID uint) ([]repositories.WebhookDelivery, error) {
+	if s.repo == nil {
+		return nil, errors.New("repository is nil")
+	}
+	return s.repo.FindDeliveries(webhookID, 100)
+}
+
+// Dispatch queues the change for every active webhook subscribed to it and
+// sends it in the background. Finishing a wash is also sent as vehicle.finished.
+func (s *WebhookService) Dispatch(change VehicleChange, vehicle *repositories.Vehicle) {
+	if s == nil || s.repo == nil {
+		return
+	}
+	events := []string{change.Type}
+	if change.Type == ChangeTransitioned && change.Process == ProcessFinish {
+		events = append(events, ChangeFinished)
+	}
+	webhooks, err := s.repo.FindActive()
+	if err != nil {
+		log.Printf("Failed to load webhooks: %v", err)
+		return
+	}
+	for _, event := range events {
+		payload, err := json.Marshal(WebhookPayload{
+			Event:      event,
+			OccurredAt: time.Now().UTC(),
+			Vehicle:    toWebhookVehicle(vehicle),
+		})
+		if err != nil {
+			log.Printf("Failed to encode webhook payload: %v", err)
+			return
+		}
+		for _, webhook := range webhooks {
+			if !webhook.Subscribes(event) {
+				continue
+			}
+			lease := time.Now().UTC().Add(webhookLease)
+			delivery := &repositories.WebhookDelivery{
+				WebhookID:     webhook.ID,
+				Event:         event,
+				Payload:       string(payload),
+				Status:        repositories.DeliveryPending,
+				NextAttemptAt: &lease,
+			}
+			if err := s.repo.CreateDelivery(delivery); err != nil {
+				log.Printf("Failed to queue webhook delivery: %v", err)
+				continue
+			}
+			go s.attempt(webhook, delivery)
+		}
+	}
+}
+
+// Redeliver sends the payload of an earlier delivery again as a new delivery
+func (s *WebhookService) Redeliver(webhookID, deliveryID uint) error {
+	if s.repo == nil {
+		return errors.New("repository is nil")
+	}
+	webhook, err := s.repo.FindByID(webhookID)
+	if err != nil {
+		return err
+	}
+	original, err := s.repo.FindDelivery(deliveryID)
+	if err != nil {
+		return err
+	}
+	if original.WebhookID != webhook.ID {
+		return fmt.Errorf("delivery %d does not belong to webhook %d", deliveryID, webhookID)
+	}
+	lease := time.Now().UTC().Add(webhookLease)
+	delivery := &repositories.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        repositories.DeliveryPending,
+		NextAttemptAt: &lease,
+	}
+	if err := s.repo.CreateDelivery(delivery); err != nil {
+		return err
+	}
+	s.attempt(*webhook, delivery)
+	return nil
+}
+
+// RetryDue keeps retrying failed deliveries in the background once their backoff has passed
+func (s *WebhookService) RetryDue(interval time.Duration) {
+	for range time.Tick(interval) {
+		deliveries, err := s.repo.FindDue(time.Now())
+		if err != nil {
+			log.Printf("Failed to load webhook deliveries to retry: %v", err)
+			continue
+		}
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			webhook, err := s.repo.FindByID(delivery.WebhookID)
+			if err != nil {
+				log.Printf("Failed to load webhook %d: %v", delivery.WebhookID, err)
+				continue
+			}
+			if !webhook.Active {
+				continue
+			}
+			// Another server sharing the database may be retrying it too
+			now := time.Now()
+			claimed, err := s.repo.ClaimDelivery(delivery.ID, now, now.Add(webhookLease))
+			if err != nil {
+				log.Printf("Failed to claim webhook delivery %d: %v", delivery.ID, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			s.attempt(*webhook, delivery)
+		}
+	}
+}
+
+// attempt posts the delivery once and records the outcome, scheduling the
+// next attempt with exponential backoff when it failed
+func (s *WebhookService) attempt(webhook repositories.Webhook, delivery *repositories.WebhookDelivery) {
+	delivery.Attempts++
+	status, err := s.post(webhook, delivery)
+	delivery.ResponseStatus = status
+	delivery.NextAttemptAt = nil
+	if err != nil {
+		delivery.Status = repositories.DeliveryFailed
+		delivery.Error = err.Error()
+		if delivery.Attempts < MaxWebhookAttempts {
+			next := time.Now().UTC().Add(webhookBackoff(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+		}
+	} else {
+		now := time.Now().UTC()
+		delivery.Status = repositories.DeliveryDelivered
+		delivery.Error = ""
+		delivery.DeliveredAt = &now
+	}
+	if err := s.repo.SaveDelivery(delivery); err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// post sends the payload signed with the webhook's secret. The receiver
+// checks X-Webhook-Signature, "sha256=" followed by the hex HMAC-SHA256 of
+// the timestamp header, a dot and the body.
+func (s *WebhookService) post(webhook repositories.Webhook, delivery *repositories.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NevaCarwash-Webhook/1")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if excerpt := responseExcerpt(body); excerpt != "" {
+			return resp.StatusCode, fmt.Errorf("receiver answered %d: %s", resp.StatusCode, excerpt)
+		}
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// responseExcerpt keeps the start of a response body for the delivery log,
+// on one line and without control characters
+func responseExcerpt(body []byte) string {
+	text := strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return ' '
+		}
+		return r
+	}, string(body))
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > webhookExcerptLength {
+		text = string(runes[:webhookExcerptLength]) + "…"
+	}
+	return text
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.body" with the secret
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff doubles the wait after every failed attempt, up to a limit
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookFirstRetry
+	for i := 1; i < attempts && wait < webhookMaxRetry; i++ {
+		wait *= 2
+	}
+	if wait > webhookMaxRetry {
+		wait = webhookMaxRetry
+	}
+	return wait
+}
+
+func applyWebhookRequest(webhook *repositories.Webhook, input repositories.WebhookRequest) error {
+	url := strings.TrimSpace(input.URL)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return repositories.ErrInvalidWebhookURL
+	}
+	for _, event := range input.Events {
+		if !validWebhookEvent(event) {
+			return fmt.Errorf("%w: %s", repositories.ErrUnknownWebhookEvent, event)
+		}
+	}
+	secret := strings.TrimSpace(input.Secret)
+	if secret == "" {
+		secret = webhook.Secret
+	}
+	if secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	webhook.URL = url
+	webhook.Secret = secret
+	webhook.Events = strings.Join(input.Events, ",")
+	webhook.Active = input.Active
+	return nil
+}
+
+func validWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+func toWebhookVehicle(vehicle *repositories.Vehicle) WebhookVehicle {
+	return WebhookVehicle{
+		ID:                  vehicle.ID,
+		Queue:               vehicle.Queue,
+		Date:                vehicle.Date.In(repositories.BusinessLocation).Format("2006-01-02"),
+		Name:                vehicle.Name,
+		Contact:             vehicle.Contact,
+		Plate:               vehicle.Plate,
+		Package:             vehicle.Package,
+		Process:             vehicle.Process,
+		CustomerID:          vehicle.CustomerID,
+		RegisteredVehicleID: vehicle.RegisteredVehicleID,
+		EnterTime:           vehicle.EnterTime,
+		EstimatedTime:       vehicle.EstimatedTime,
+		FinishTime:          vehicle.FinishTime,
+	}
+}